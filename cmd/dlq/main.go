@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"l0/internal/config"
+	"l0/internal/kafka"
+	"l0/internal/models"
+	"l0/internal/storage"
+	"l0/internal/storage/postgres"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+type replayConfig struct {
+	Brokers []string            `yaml:"brokers" env-required:"true"`
+	Topic   string              `yaml:"topic" env-default:"dlq"`    // dead-letter topic to read from
+	Writer  config.WriterConfig `yaml:"writer" env-required:"true"` // orders topic to republish to
+}
+
+type dbConfig struct {
+	Storage config.Storage `yaml:"storage" env-required:"true"`
+}
+
+type filter struct {
+	classes []string
+	uids    []string
+	since   time.Time
+	until   time.Time
+}
+
+func (f filter) match(dl kafka.DeadLetter, uid string) bool {
+	if len(f.classes) > 0 && !slices.Contains(f.classes, dl.Class) {
+		return false
+	}
+	if len(f.uids) > 0 && !slices.Contains(f.uids, uid) {
+		return false
+	}
+	if !f.since.IsZero() && dl.Time.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && !dl.Time.Before(f.until) {
+		return false
+	}
+	return true
+}
+
+func main() {
+	// dlq reads the dead-letter topic and replays selected messages,
+	// either back to the orders topic or straight into the database
+	os.Exit(run())
+}
+
+// run replays the dead letters and returns the exit code: 1 if reading stopped on an error
+// or a selected message failed to replay, so scripts can tell
+func run() int {
+	var (
+		classes = flag.String("class", "", "comma-separated error classes to replay (validation, conflict, transition, save, poison)")
+		uids    = flag.String("uid", "", "comma-separated order UIDs to replay")
+		since   = flag.String("since", "", "replay messages dead-lettered at or after this RFC 3339 time")
+		until   = flag.String("until", "", "replay messages dead-lettered before this RFC 3339 time")
+		target  = flag.String("target", "kafka", "where to replay to: kafka | db")
+		dryRun  = flag.Bool("dry-run", false, "only validate and report, write nothing")
+	)
+	flag.Parse()
+
+	confPath := os.Getenv("CONFIG_PATH")
+	if confPath == "" {
+		panic("CONFIG_PATH env variable not set")
+	}
+	var cfg replayConfig
+	if err := cleanenv.ReadConfig(confPath, &cfg); err != nil {
+		panic(err)
+	}
+
+	f := filter{classes: split(*classes), uids: split(*uids)}
+	var err error
+	if f.since, err = parseTime(*since); err != nil {
+		panic(err)
+	}
+	if f.until, err = parseTime(*until); err != nil {
+		panic(err)
+	}
+
+	var save func(context.Context, kafka.DeadLetter, *models.Order) error
+	switch *target {
+	case "kafka":
+		// synchronous, so a message only counts as replayed once the brokers have it
+		w := kafka.NewSyncWriter(cfg.Writer, cfg.Brokers)
+		defer func() { _ = w.Close() }()
+		save = func(ctx context.Context, dl kafka.DeadLetter, _ *models.Order) error {
+			return w.Republish(ctx, dl)
+		}
+	case "db":
+		var db dbConfig
+		if err := cleanenv.ReadConfig(confPath, &db); err != nil {
+			panic(err)
+		}
+		st, err := postgres.NewStorage(db.Storage)
+		if err != nil {
+			panic(err)
+		}
+		defer func() { _ = st.Close() }()
+		// through the inbox, keyed by the dead letter, so replaying it twice applies it once;
+		// the save notifies running replicas like any other
+		save = func(ctx context.Context, dl kafka.DeadLetter, o *models.Order) error {
+			return st.SaveMessage(ctx, o, dl.Letter)
+		}
+	default:
+		panic("Unknown target: " + *target)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	v := validator.New()
+
+	var (
+		read, selected, invalid, skipped, replayed, failed int
+		readErr                                            error
+	)
+	for dl, err := range kafka.ReadDeadLetters(ctx, cfg.Brokers, cfg.Topic) {
+		if err != nil {
+			readErr = err
+			fmt.Println("failed to read dead letters:", err)
+			break
+		}
+		read++
+
+		var order models.Order
+		decodeErr := json.Unmarshal(dl.Raw.Value, &order)
+		if !f.match(dl, order.OrderUID) {
+			continue
+		}
+		selected++
+
+		src := fmt.Sprintf("%s/%d@%d", dl.Raw.Topic, dl.Raw.Partition, dl.Raw.Offset)
+		if decodeErr != nil {
+			invalid++
+			fmt.Printf("skip %s: undecodable: %v\n", src, decodeErr)
+			continue
+		}
+		// same rules as handlers.HandleSaves
+		if err := v.Struct(order); err != nil {
+			invalid++
+			fmt.Printf("skip %s order %s: invalid: %v\n", src, order.OrderUID, err)
+			continue
+		}
+		if *dryRun {
+			fmt.Printf("would replay %s order %s (class %q)\n", src, order.OrderUID, dl.Class)
+			continue
+		}
+		err := save(ctx, dl, &order)
+		if errors.Is(err, storage.ErrDuplicateMessage) || errors.Is(err, storage.ErrStaleOrder) {
+			skipped++
+			fmt.Printf("skip %s order %s: %v\n", src, order.OrderUID, err)
+			continue
+		}
+		if err != nil {
+			failed++
+			fmt.Printf("failed %s order %s: %v\n", src, order.OrderUID, err)
+			continue
+		}
+		replayed++
+		fmt.Printf("replayed %s order %s\n", src, order.OrderUID)
+	}
+
+	fmt.Printf("read %d, selected %d, invalid %d, skipped %d, replayed %d, failed %d\n", read, selected, invalid, skipped, replayed, failed)
+	if readErr != nil || failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
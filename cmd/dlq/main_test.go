@@ -0,0 +1,44 @@
+package main
+
+import (
+	"l0/internal/kafka"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter_Match(t *testing.T) {
+	at := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	dl := kafka.DeadLetter{Class: kafka.ClassConflict, Time: at}
+	tests := []struct {
+		name   string
+		filter filter
+		uid    string
+		want   bool
+	}{
+		{"no filter", filter{}, "a", true},
+		{"class", filter{classes: []string{kafka.ClassSave, kafka.ClassConflict}}, "a", true},
+		{"other class", filter{classes: []string{kafka.ClassSave}}, "a", false},
+		{"uid", filter{uids: []string{"b", "a"}}, "a", true},
+		{"other uid", filter{uids: []string{"b"}}, "a", false},
+		{"undecodable has no uid", filter{uids: []string{"a"}}, "", false},
+		{"since is inclusive", filter{since: at}, "a", true},
+		{"before since", filter{since: at.Add(time.Second)}, "a", false},
+		{"until is exclusive", filter{until: at}, "a", false},
+		{"before until", filter{until: at.Add(time.Second)}, "a", true},
+		{"within range", filter{since: at.Add(-time.Hour), until: at.Add(time.Hour)}, "a", true},
+		{"all of them", filter{classes: []string{kafka.ClassConflict}, uids: []string{"a"}, since: at, until: at.Add(time.Hour)}, "a", true},
+		{"one of them fails", filter{classes: []string{kafka.ClassConflict}, uids: []string{"b"}, since: at}, "a", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.match(dl, tt.uid))
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	assert.Nil(t, split(""))
+	assert.Equal(t, []string{"save", "conflict"}, split("save, conflict"))
+}
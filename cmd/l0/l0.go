@@ -6,6 +6,8 @@ import (
 	"l0/internal/config"
 	"l0/internal/handlers"
 	"l0/internal/kafka"
+	"l0/internal/metrics"
+	"l0/internal/retry"
 	"l0/internal/storage/cache"
 	"l0/internal/storage/postgres"
 	"net/http"
@@ -22,8 +24,12 @@ import (
 )
 
 func main() {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		os.Exit(healthcheck())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
 	var cfg config.Config
 	initCfg.MustParseConfig(&cfg)
 	log := initLog.SetupLogger(cfg.Env)
@@ -34,28 +40,69 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	cacher := cache.NewCache(cfg.Cache.TTL, cfg.Cache.Limit)
-
-	err = handlers.LoadCache(ctx, cacher, st)
+	cacher, err := cache.New(cfg.Cache)
 	if err != nil {
 		panic(err)
 	}
+	missing := cache.NewNegative(cfg.Cache.NegativeTTL, cfg.Cache.NegativeLimit)
+	var orderGetter handlers.OrderGetter = st
+	if cfg.Cache.Coalesce {
+		orderGetter = handlers.Coalesce(st, cfg.Server.Timeout)
+	}
+	// other replicas' saves reach this cache through postgres notifications
+	listener, err := postgres.NewListener(cfg.Storage)
+	if err != nil {
+		panic(err)
+	}
+	invCh, invErrCh := listener.Invalidations(ctx)
+	handlers.HandleInvalidations(ctx, log, invCh, cacher, missing)
+
+	// warm the cache up in the background, from a snapshot if there is one; /readyz reports when it's done
+	warmedUp := make(chan struct{})
+	warmUp := func() error {
+		return handlers.LoadCache(ctx, log, cacher, st, cfg.Cache.Limit, cfg.Cache.WarmupBatch)
+	}
+	var snapshotsDone <-chan struct{}
+	if cfg.Cache.SnapshotPath != "" {
+		snaps := cache.NewSnapshotFile(cfg.Cache.SnapshotPath, cacher)
+		snapshotsDone = handlers.SnapshotCache(ctx, log, snaps, cfg.Cache.SnapshotInterval, warmedUp)
+		warmUp = func() error {
+			return handlers.RestoreCache(ctx, log, cacher, st, st, snaps, cfg.Cache.Limit, cfg.Cache.WarmupBatch)
+		}
+	}
+	go func() {
+		if err := warmUp(); err != nil {
+			log.Error("failed to warm the cache up", sl.Err(err))
+			stop()
+			return
+		}
+		close(warmedUp)
+	}()
 
 	kr := kafka.NewReader(cfg.Kafka.Reader, cfg.Kafka.Brokers)
-	dlq := kafka.NewWriter(cfg.Kafka.Writer, cfg.Kafka.Brokers)
+	dlq := kafka.NewSyncWriter(cfg.Kafka.Writer, cfg.Kafka.Brokers)
 	msgCh, errCh, commitFunc := kr.Messages(ctx)
-	saveErrCh := handlers.HandleSaves(ctx, log, st, msgCh, dlq, commitFunc, validate)
+	retrier := retry.New(cfg.Retry, postgres.Retryable)
+	var saveErrCh <-chan error
+	var savesDone <-chan struct{}
+	if size := min(cfg.Kafka.Reader.BatchSize, postgres.MaxBatchSize); size > 1 {
+		saveErrCh, savesDone = handlers.HandleSaveBatches(ctx, log, st, msgCh, dlq, commitFunc, validate, retrier, cacher, size, cfg.Kafka.Reader.BatchWait)
+	} else {
+		saveErrCh, savesDone = handlers.HandleSaves(ctx, log, st, msgCh, dlq, commitFunc, validate, retrier, cacher)
+	}
 
 	handlers.HandleErrors(ctx, log, errCh)
 	handlers.HandleErrors(ctx, log, saveErrCh)
+	handlers.HandleErrors(ctx, log, invErrCh)
 
 	e := echo.New()
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins: []string{"*"},
 		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept},
-		AllowMethods: []string{http.MethodGet}, // only GET allowed
+		AllowMethods: []string{http.MethodGet, http.MethodPost}, // POST is only for batch reads
 	}))
 	e.Use(middleware.Logger())
+	e.Use(metrics.HTTPMiddleware())
 	e.Use(middleware.Recover())
 	e.Use(middleware.RequestID())
 	e.Use(middleware.TimeoutWithConfig(middleware.TimeoutConfig{Timeout: cfg.Server.Timeout}))
@@ -68,16 +115,79 @@ func main() {
 		IdleTimeout:  cfg.Server.IdleTimeout,
 	}
 
-	e.GET("/order/:id", handlers.GetOrderHandler(st, cacher))
+	e.GET("/order/:id", handlers.GetOrderHandler(orderGetter, cacher, missing))
+	e.GET("/order/:id/history", handlers.OrderHistoryHandler(st))
+	e.GET("/orders", handlers.ListOrdersHandler(st))
+	e.POST(`/orders\:batchGet`, handlers.BatchGetHandler(st, cacher)) // literal colon
+	e.GET("/orders/by-track/:track", handlers.OrdersByTrackHandler(st, cacher))
+	e.GET("/orders/by-transaction/:tx", handlers.OrdersByTransactionHandler(st, cacher))
+	e.GET("/customers/:customer_id", handlers.CustomerHandler(st))
+	e.GET("/customers/:customer_id/orders", handlers.CustomerOrdersHandler(st, st))
+	e.GET("/customers/:customer_id/addresses", handlers.CustomerAddressesHandler(st))
+	e.GET("/metrics", echo.WrapHandler(metrics.Handler()))
+	e.GET("/healthz", handlers.HealthHandler())
+	e.GET("/readyz", handlers.ReadyHandler(map[string]handlers.CheckFunc{
+		"postgres":      st.Ping,
+		"invalidations": listener.Ping,
+		"kafka_reader":  kr.CheckAlive,
+		"kafka_dlq":     dlq.CheckAlive,
+		"cache":         handlers.WarmUpCheck(warmedUp),
+	}, cfg.Server.Timeout))
 
-	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Error("failed to start", sl.Err(err))
-	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("failed to start", sl.Err(err))
+			stop()
+		}
+	}()
 
 	// graceful shutdown
-	down := make(chan os.Signal, 1)
-	signal.Notify(down, os.Signal(syscall.SIGTERM), os.Signal(syscall.SIGINT))
-	<-down
+	<-ctx.Done()
 	log.Info("shutting down")
 
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Error("failed to shut down http server", sl.Err(err))
+		}
+		// the consumer stops fetching on ctx cancellation; wait for the message in flight
+		select {
+		case <-savesDone:
+		case <-shutdownCtx.Done():
+			log.Error("timed out waiting for the message in flight")
+		}
+		// the last snapshot is taken on ctx cancellation too
+		if snapshotsDone != nil {
+			select {
+			case <-snapshotsDone:
+			case <-shutdownCtx.Done():
+				log.Error("timed out waiting for the cache snapshot")
+			}
+		}
+		if err := dlq.Close(); err != nil {
+			log.Error("failed to flush dlq writer", sl.Err(err))
+		}
+		if err := kr.Close(); err != nil {
+			log.Error("failed to close kafka reader", sl.Err(err))
+		}
+		if err := listener.Close(); err != nil {
+			log.Error("failed to close invalidation listener", sl.Err(err))
+		}
+		cacher.Stop()
+		if err := st.Close(); err != nil {
+			log.Error("failed to close storage", sl.Err(err))
+		}
+	}()
+
+	select {
+	case <-finished:
+		log.Info("shut down gracefully")
+	case <-shutdownCtx.Done():
+		log.Error("shutdown timed out", sl.Err(shutdownCtx.Err()))
+	}
 }
@@ -10,6 +10,8 @@ import (
 	"l0/internal/models"
 	"log"
 	"net/http"
+	"sync"
+	"time"
 
 	initCfg "github.com/kxddry/go-utils/pkg/config"
 	"github.com/labstack/echo/v4"
@@ -24,6 +26,7 @@ func main() {
 	initCfg.MustParseConfig(&cfg)
 	e := echo.New()
 	kw := kafka.NewWriter[models.Order](cfg.Writer, cfg.Brokers)
+	var versions versioner
 
 	e.POST("/save", func(c echo.Context) error {
 		body := c.Request().Body
@@ -32,6 +35,10 @@ func main() {
 		if err := json.Unmarshal(bytes, &order); err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
+		if order.Version == 0 {
+			// without a version an update of a stored order would be taken for a conflict
+			order.Version = versions.next()
+		}
 		err := kw.Write(ctx, order)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
@@ -50,3 +57,17 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// versioner hands out increasing order versions: milliseconds since the epoch,
+// bumped when two orders are sent within the same millisecond
+type versioner struct {
+	mu   sync.Mutex
+	last int64
+}
+
+func (v *versioner) next() int64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.last = max(time.Now().UnixMilli(), v.last+1)
+	return v.last
+}
@@ -9,6 +9,7 @@ type Config struct {
 	Kafka   Kafka   `yaml:"kafka"`
 	Server  Server  `yaml:"server"`
 	Cache   Cache   `yaml:"cache"`
+	Retry   Retry   `yaml:"retry"`
 }
 
 // Storage is a structure with configs for PostgreSQL
@@ -23,15 +24,45 @@ type Storage struct {
 
 // Server is a structure with configs for an HTTP server
 type Server struct {
-	Address     string        `yaml:"address" env-required:"true"`
-	Timeout     time.Duration `yaml:"timeout" env-default:"3s"`
-	IdleTimeout time.Duration `yaml:"idle_timeout" env-default:"60s"`
+	Address         string        `yaml:"address" env-required:"true"`
+	Timeout         time.Duration `yaml:"timeout" env-default:"3s"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout" env-default:"60s"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env-default:"15s"` // bounds the whole graceful shutdown
 }
 
 // Cache is a structure with configs for creating cache
 type Cache struct {
-	TTL   time.Duration `yaml:"ttl" env-default:"15m"`
-	Limit int           `yaml:"limit" env-default:"1000"`
+	TTL              time.Duration `yaml:"ttl" env-default:"15m"`
+	Limit            int           `yaml:"limit" env-default:"1000"`
+	Policy           string        `yaml:"policy" env-default:"lru"`           // eviction policy: lru | lfu | tinylfu
+	Shards           int           `yaml:"shards" env-default:"0"`             // > 0 splits the cache into independently locked shards, approximate lru only
+	WarmupBatch      int           `yaml:"warmup_batch" env-default:"200"`     // orders per warm-up query
+	Coalesce         bool          `yaml:"coalesce" env-default:"true"`        // concurrent misses of an order share one database read
+	NegativeTTL      time.Duration `yaml:"negative_ttl" env-default:"5s"`      // how long a missing order is answered from memory, 0 disables
+	NegativeLimit    int           `yaml:"negative_limit" env-default:"10000"` // missing orders remembered at most
+	SnapshotPath     string        `yaml:"snapshot_path"`                      // file the cache is kept in across restarts, empty disables snapshots
+	SnapshotInterval time.Duration `yaml:"snapshot_interval" env-default:"1m"` // how often the snapshot is rewritten
+	L2               string        `yaml:"l2"`                                 // second-level cache shared by replicas: none if empty | redis
+	Redis            Redis         `yaml:"redis"`
+}
+
+// Redis is a structure with configs for a Redis-compatible server used as a second-level cache
+type Redis struct {
+	Address  string        `yaml:"address" env-default:"localhost:6379"`
+	Password string        `env:"REDIS_PASSWORD"`
+	DB       int           `yaml:"db" env-default:"0"`
+	Prefix   string        `yaml:"prefix" env-default:"l0:order:"`
+	TTL      time.Duration `yaml:"ttl" env-default:"1h"`
+	Timeout  time.Duration `yaml:"timeout" env-default:"100ms"` // per command, a slow second level is worse than none
+}
+
+// Retry is a structure with configs for retrying failed saves before giving up on them
+type Retry struct {
+	MaxAttempts    int           `yaml:"max_attempts" env-default:"5"`
+	InitialBackoff time.Duration `yaml:"initial_backoff" env-default:"100ms"`
+	MaxBackoff     time.Duration `yaml:"max_backoff" env-default:"5s"`
+	Multiplier     float64       `yaml:"multiplier" env-default:"2"`
+	Jitter         float64       `yaml:"jitter" env-default:"0.2"` // fraction of the delay, 0..1
 }
 
 // Kafka is a structure with configs for a broker like Kafka
@@ -49,6 +80,8 @@ type ReaderConfig struct {
 	MaxBytes       int           `yaml:"max_bytes" env-default:"1048576"`   // 1MB
 	CommitInterval time.Duration `yaml:"commit_interval" env-default:"1s"`  // time.Duration, e.g. 1s
 	StartOffset    string        `yaml:"start_offset" env-default:"latest"` // earliest | latest
+	BatchSize      int           `yaml:"batch_size" env-default:"1"`        // messages saved in one transaction, 1 saves them one by one
+	BatchWait      time.Duration `yaml:"batch_wait" env-default:"100ms"`    // how long a batch waits to fill up after its first message
 }
 
 // WriterConfig is a structure with config for kafka writer
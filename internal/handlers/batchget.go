@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"l0/internal/models"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+const maxBatchGet = 100
+
+// OrdersGetter gets many orders at once, skipping unknown UIDs
+type OrdersGetter interface {
+	OrdersByUIDs(context.Context, []string) ([]*models.Order, error)
+}
+
+type batchGetRequest struct {
+	OrderUIDs []string `json:"order_uids"`
+}
+
+type batchGetResult struct {
+	OrderUID string        `json:"order_uid"`
+	Found    bool          `json:"found"`
+	Order    *models.Order `json:"order,omitempty"`
+}
+
+type batchGetResponse struct {
+	Results []batchGetResult `json:"results"`
+}
+
+// BatchGetHandler handles POST /orders:batchGet. It answers from the cache where it can and
+// fetches the rest from storage at once, with one result per requested UID in request order.
+func BatchGetHandler(getter OrdersGetter, cacher Cacher) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+
+		var req batchGetRequest
+		if err := c.Bind(&req); err != nil {
+			return c.String(http.StatusBadRequest, "body must be {\"order_uids\": [...]}")
+		}
+		if len(req.OrderUIDs) == 0 || len(req.OrderUIDs) > maxBatchGet {
+			return c.String(http.StatusBadRequest, fmt.Sprintf("order_uids must hold 1 to %d UIDs", maxBatchGet))
+		}
+
+		found := make(map[string]*models.Order, len(req.OrderUIDs))
+		var misses []string
+		for _, uid := range req.OrderUIDs {
+			if _, ok := found[uid]; ok {
+				continue
+			}
+			if order, err := cacher.GetOrder(ctx, uid); err == nil {
+				found[uid] = order
+				continue
+			}
+			found[uid] = nil // asked for, once
+			misses = append(misses, uid)
+		}
+
+		if len(misses) > 0 {
+			orders, err := getter.OrdersByUIDs(ctx, misses)
+			if err != nil {
+				return c.String(http.StatusInternalServerError, err.Error())
+			}
+			for _, o := range orders {
+				found[o.OrderUID] = o
+			}
+			_ = cacher.LoadOrders(ctx, orders) // nil always
+		}
+
+		res := batchGetResponse{Results: make([]batchGetResult, len(req.OrderUIDs))}
+		for i, uid := range req.OrderUIDs {
+			res.Results[i] = batchGetResult{OrderUID: uid, Found: found[uid] != nil, Order: found[uid]}
+		}
+		return c.JSON(http.StatusOK, res)
+	}
+}
@@ -0,0 +1,129 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"l0/internal/handlers"
+	"l0/internal/models"
+	"l0/internal/storage/cache"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeOrders has the orders listed and records every OrdersByUIDs call
+type fakeOrders struct {
+	orders map[string]*models.Order
+	calls  [][]string
+	err    error
+}
+
+func (g *fakeOrders) OrdersByUIDs(_ context.Context, uids []string) ([]*models.Order, error) {
+	g.calls = append(g.calls, uids)
+	if g.err != nil {
+		return nil, g.err
+	}
+	var orders []*models.Order
+	for _, uid := range uids {
+		if o, ok := g.orders[uid]; ok {
+			orders = append(orders, o)
+		}
+	}
+	return orders, nil
+}
+
+type batchGetResult struct {
+	OrderUID string        `json:"order_uid"`
+	Found    bool          `json:"found"`
+	Order    *models.Order `json:"order"`
+}
+
+func batchGet(t *testing.T, g *fakeOrders, c handlers.Cacher, body string) (int, []batchGetResult) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/orders:batchGet", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := serveRequest(handlers.BatchGetHandler(g, c), req)
+	if rec.Code != http.StatusOK {
+		return rec.Code, nil
+	}
+	var res struct {
+		Results []batchGetResult `json:"results"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	return rec.Code, res.Results
+}
+
+func uidsBody(uids ...string) string {
+	b, _ := json.Marshal(map[string][]string{"order_uids": uids})
+	return string(b)
+}
+
+func TestBatchGetHandler(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewCache(time.Minute, 10)
+	defer c.Stop()
+	require.NoError(t, c.SaveOrder(ctx, &models.Order{OrderUID: "cached"}))
+	g := &fakeOrders{orders: map[string]*models.Order{"a": {OrderUID: "a"}, "b": {OrderUID: "b"}}}
+
+	code, res := batchGet(t, g, c, uidsBody("b", "unknown", "cached", "a", "b"))
+	require.Equal(t, http.StatusOK, code)
+	// one result per requested uid, in request order, repeats included
+	assert.Equal(t, []batchGetResult{
+		{OrderUID: "b", Found: true, Order: &models.Order{OrderUID: "b"}},
+		{OrderUID: "unknown"},
+		{OrderUID: "cached", Found: true, Order: &models.Order{OrderUID: "cached"}},
+		{OrderUID: "a", Found: true, Order: &models.Order{OrderUID: "a"}},
+		{OrderUID: "b", Found: true, Order: &models.Order{OrderUID: "b"}},
+	}, res)
+	// cache hits aren't fetched, repeats are fetched once
+	assert.Equal(t, [][]string{{"b", "unknown", "a"}}, g.calls)
+
+	// what was fetched is cached now
+	g.calls = nil
+	code, res = batchGet(t, g, c, uidsBody("a", "b"))
+	require.Equal(t, http.StatusOK, code)
+	assert.Len(t, res, 2)
+	assert.Empty(t, g.calls)
+}
+
+func TestBatchGetHandler_BadRequest(t *testing.T) {
+	tooMany := make([]string, 101)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprint(i)
+	}
+	full := make([]string, 100)
+	copy(full, tooMany)
+
+	c := cache.NewCache(time.Minute, 10)
+	defer c.Stop()
+	for name, body := range map[string]string{
+		"none":      uidsBody(),
+		"missing":   `{}`,
+		"too many":  uidsBody(tooMany...),
+		"malformed": `{"order_uids": "a"}`,
+	} {
+		g := &fakeOrders{}
+		code, _ := batchGet(t, g, c, body)
+		assert.Equal(t, http.StatusBadRequest, code, name)
+		assert.Empty(t, g.calls, name)
+	}
+
+	code, res := batchGet(t, &fakeOrders{}, c, uidsBody(full...))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, res, 100)
+}
+
+func TestBatchGetHandler_StorageError(t *testing.T) {
+	c := cache.NewCache(time.Minute, 10)
+	defer c.Stop()
+	code, _ := batchGet(t, &fakeOrders{err: errors.New("connection reset")}, c, uidsBody("a"))
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
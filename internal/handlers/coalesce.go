@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"context"
+	"l0/internal/metrics"
+	"l0/internal/models"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+type coalescing struct {
+	getter  OrderGetter
+	group   singleflight.Group
+	timeout time.Duration
+}
+
+// Coalesce makes concurrent reads of the same order share a single getter call.
+// The shared call isn't cancelled with the request that started it, timeout bounds it instead;
+// every caller still stops waiting when its own ctx is done.
+func Coalesce(getter OrderGetter, timeout time.Duration) OrderGetter {
+	return &coalescing{getter: getter, timeout: timeout}
+}
+
+// GetOrder gets an order, joining a read of it already in flight
+func (g *coalescing) GetOrder(ctx context.Context, id string) (*models.Order, error) {
+	ch := g.group.DoChan(id, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), g.timeout)
+		defer cancel()
+		return g.getter.GetOrder(ctx, id)
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		if res.Shared {
+			metrics.CoalescedReads.Inc()
+		}
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		order := res.Val.(*models.Order)
+		if res.Shared {
+			order = order.Clone() // callers may modify what they get
+		}
+		return order, nil
+	}
+}
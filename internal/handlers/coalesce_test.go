@@ -0,0 +1,96 @@
+package handlers_test
+
+import (
+	"context"
+	"l0/internal/handlers"
+	"l0/internal/models"
+	"l0/internal/storage"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowGetter counts reads and holds each one until release is closed
+type slowGetter struct {
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (g *slowGetter) GetOrder(ctx context.Context, id string) (*models.Order, error) {
+	g.calls.Add(1)
+	select {
+	case <-g.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if id == "missing" {
+		return nil, storage.ErrOrderNotFound
+	}
+	return &models.Order{OrderUID: id, Items: []models.Item{{Name: "Mascaras"}}}, nil
+}
+
+func TestCoalesce(t *testing.T) {
+	db := &slowGetter{release: make(chan struct{})}
+	getter := handlers.Coalesce(db, time.Second)
+
+	const n = 10
+	var (
+		wg     sync.WaitGroup
+		orders = make([]*models.Order, n)
+		errs   = make([]error, n)
+	)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			orders[i], errs[i] = getter.GetOrder(context.Background(), "a")
+		}()
+	}
+	require.Eventually(t, func() bool { return db.calls.Load() == 1 }, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond) // let the rest join
+	close(db.release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), db.calls.Load())
+	for i := range n {
+		require.NoError(t, errs[i])
+		assert.Equal(t, "a", orders[i].OrderUID)
+	}
+	orders[0].Items[0].Name = "changed" // every caller got its own copy
+	assert.Equal(t, "Mascaras", orders[1].Items[0].Name)
+
+	_, err := getter.GetOrder(context.Background(), "missing")
+	assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+}
+
+func TestCoalesce_CallerCancelled(t *testing.T) {
+	db := &slowGetter{release: make(chan struct{})}
+	getter := handlers.Coalesce(db, time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, err := getter.GetOrder(ctx, "a")
+		leader <- err
+	}()
+	require.Eventually(t, func() bool { return db.calls.Load() == 1 }, time.Second, time.Millisecond)
+
+	follower := make(chan error, 1)
+	go func() {
+		_, err := getter.GetOrder(context.Background(), "a")
+		follower <- err
+	}()
+	time.Sleep(20 * time.Millisecond) // let it join
+
+	// the request that started the read goes away, the read goes on
+	cancel()
+	assert.ErrorIs(t, <-leader, context.Canceled)
+
+	close(db.release)
+	assert.NoError(t, <-follower)
+	assert.Equal(t, int32(1), db.calls.Load())
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"l0/internal/storage"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CustomerGetter gets customer profiles
+type CustomerGetter interface {
+	GetCustomer(context.Context, string) (*storage.Customer, error)
+}
+
+// AddressLister lists the addresses a customer has used
+type AddressLister interface {
+	CustomerAddresses(context.Context, string) ([]storage.Address, error)
+}
+
+func customerError(c echo.Context, id string, err error) error {
+	if errors.Is(err, storage.ErrCustomerNotFound) {
+		return c.String(http.StatusNotFound, fmt.Sprintf("customer %s not found", id))
+	}
+	return c.String(http.StatusInternalServerError, err.Error())
+}
+
+// CustomerHandler handles GET /customers/:customer_id
+func CustomerHandler(getter CustomerGetter) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id := c.Param("customer_id")
+		if id == "" {
+			return echo.ErrNotFound
+		}
+
+		customer, err := getter.GetCustomer(c.Request().Context(), id)
+		if err != nil {
+			return customerError(c, id, err)
+		}
+		return c.JSON(http.StatusOK, customer)
+	}
+}
+
+// CustomerOrdersHandler handles GET /customers/:customer_id/orders, taking the same query parameters as GET /orders
+func CustomerOrdersHandler(getter CustomerGetter, lister OrderLister) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		id := c.Param("customer_id")
+		if id == "" {
+			return echo.ErrNotFound
+		}
+
+		f, err := parseOrderFilter(c)
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		f.CustomerID = id
+
+		page, err := lister.ListOrders(ctx, f)
+		if err != nil {
+			if errors.Is(err, storage.ErrInvalidCursor) {
+				return c.String(http.StatusBadRequest, storage.ErrInvalidCursor.Error())
+			}
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		// an empty first page may just as well mean there is no such customer
+		if len(page.Orders) == 0 && f.Cursor == "" {
+			if _, err := getter.GetCustomer(ctx, id); err != nil {
+				return customerError(c, id, err)
+			}
+		}
+		return c.JSON(http.StatusOK, page)
+	}
+}
+
+// CustomerAddressesHandler handles GET /customers/:customer_id/addresses
+func CustomerAddressesHandler(lister AddressLister) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id := c.Param("customer_id")
+		if id == "" {
+			return echo.ErrNotFound
+		}
+
+		addresses, err := lister.CustomerAddresses(c.Request().Context(), id)
+		if err != nil {
+			return customerError(c, id, err)
+		}
+		return c.JSON(http.StatusOK, addresses)
+	}
+}
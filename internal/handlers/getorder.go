@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"l0/internal/metrics"
 	"l0/internal/models"
 	"l0/internal/storage"
 	"net/http"
@@ -23,8 +24,15 @@ type Cacher interface {
 	LoadOrders(context.Context, []*models.Order) error
 }
 
-// GetOrderHandler handles GET requests
-func GetOrderHandler(getter OrderGetter, cacher Cacher) echo.HandlerFunc {
+// MissCache remembers orders storage doesn't have
+type MissCache interface {
+	Missing(string) bool
+	MarkMissing(string)
+}
+
+// GetOrderHandler handles GET requests. Orders storage recently didn't have are answered
+// from missing; wrap getter with Coalesce to share reads between concurrent misses.
+func GetOrderHandler(getter OrderGetter, cacher Cacher, missing MissCache) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		ctx := c.Request().Context()
 
@@ -37,15 +45,21 @@ func GetOrderHandler(getter OrderGetter, cacher Cacher) echo.HandlerFunc {
 		if err == nil {
 			return c.JSON(http.StatusOK, cache)
 		}
+		if missing.Missing(id) {
+			metrics.CacheNegativeHits.Inc()
+			return c.String(http.StatusNotFound, fmt.Sprintf("order %s not found", id))
+		}
 
 		order, err := getter.GetOrder(ctx, id)
 		if err != nil {
 			if errors.Is(err, storage.ErrOrderNotFound) {
+				missing.MarkMissing(id)
 				return c.String(http.StatusNotFound, fmt.Sprintf("order %s not found", id))
 			}
 			return c.String(http.StatusInternalServerError, err.Error())
 		}
-		_ = cacher.SaveOrder(ctx, order) // nil always
+		// a fill, not a save: a newer version cached meanwhile isn't replaced
+		_ = cacher.LoadOrders(ctx, []*models.Order{order}) // nil always
 		return c.JSON(http.StatusOK, order)
 	}
 }
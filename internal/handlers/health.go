@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CheckFunc checks whether a single dependency is usable
+type CheckFunc func(ctx context.Context) error
+
+type checkResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type readiness struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+// HealthHandler is the liveness probe: the process is up and serving HTTP
+func HealthHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// WarmUpCheck fails until done is closed, e.g. while the cache is being warmed up
+func WarmUpCheck(done <-chan struct{}) CheckFunc {
+	return func(context.Context) error {
+		select {
+		case <-done:
+			return nil
+		default:
+			return errors.New("warm-up in progress")
+		}
+	}
+}
+
+// ReadyHandler is the readiness probe. It runs all checks concurrently, each bounded by timeout,
+// and answers 503 with a per-dependency breakdown if any of them fails
+func ReadyHandler(checks map[string]CheckFunc, timeout time.Duration) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx, cancel := context.WithTimeout(c.Request().Context(), timeout)
+		defer cancel()
+
+		res := readiness{Status: "ready", Checks: make(map[string]checkResult, len(checks))}
+		var (
+			mu sync.Mutex
+			wg sync.WaitGroup
+		)
+		for name, check := range checks {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r := checkResult{Status: "ok"}
+				if err := check(ctx); err != nil {
+					r = checkResult{Status: "error", Error: err.Error()}
+				}
+				mu.Lock()
+				res.Checks[name] = r
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+
+		code := http.StatusOK
+		for _, r := range res.Checks {
+			if r.Status != "ok" {
+				res.Status, code = "not ready", http.StatusServiceUnavailable
+				break
+			}
+		}
+		return c.JSON(code, res)
+	}
+}
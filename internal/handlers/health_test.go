@@ -0,0 +1,57 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"l0/internal/handlers"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ok(context.Context) error { return nil }
+
+func TestHealthHandler(t *testing.T) {
+	rec := serve(handlers.HealthHandler(), "/healthz")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, rec.Body.String())
+}
+
+func TestReadyHandler(t *testing.T) {
+	warmedUp := make(chan struct{})
+	checks := map[string]handlers.CheckFunc{"postgres": ok, "kafka": ok, "cache": handlers.WarmUpCheck(warmedUp)}
+
+	rec := serve(handlers.ReadyHandler(checks, time.Second), "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"status":"not ready","checks":{
+		"postgres":{"status":"ok"},"kafka":{"status":"ok"},"cache":{"status":"error","error":"warm-up in progress"}}}`, rec.Body.String())
+
+	close(warmedUp)
+	rec = serve(handlers.ReadyHandler(checks, time.Second), "/readyz")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"ready","checks":{"postgres":{"status":"ok"},"kafka":{"status":"ok"},"cache":{"status":"ok"}}}`,
+		rec.Body.String())
+
+	checks["postgres"] = func(context.Context) error { return errors.New("connection refused") }
+	rec = serve(handlers.ReadyHandler(checks, time.Second), "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"status":"not ready","checks":{
+		"postgres":{"status":"error","error":"connection refused"},"kafka":{"status":"ok"},"cache":{"status":"ok"}}}`, rec.Body.String())
+}
+
+func TestReadyHandler_Timeout(t *testing.T) {
+	hanging := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	start := time.Now()
+	rec := serve(handlers.ReadyHandler(map[string]handlers.CheckFunc{"postgres": ok, "kafka": hanging}, 50*time.Millisecond), "/readyz")
+
+	// a hanging dependency costs the timeout, not the probe
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"status":"not ready","checks":{
+		"postgres":{"status":"ok"},"kafka":{"status":"error","error":"context deadline exceeded"}}}`, rec.Body.String())
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"l0/internal/storage"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HistoryGetter gets the revisions of an order
+type HistoryGetter interface {
+	OrderHistory(context.Context, string) ([]storage.Revision, error)
+}
+
+// OrderHistoryHandler handles GET /order/:id/history
+func OrderHistoryHandler(getter HistoryGetter) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id := c.Param("id")
+		if id == "" {
+			return echo.ErrNotFound
+		}
+
+		revisions, err := getter.OrderHistory(c.Request().Context(), id)
+		if err != nil {
+			if errors.Is(err, storage.ErrOrderNotFound) {
+				return c.String(http.StatusNotFound, fmt.Sprintf("order %s not found", id))
+			}
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		return c.JSON(http.StatusOK, revisions)
+	}
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"context"
+	"l0/internal/storage"
+	"log/slog"
+
+	"github.com/kxddry/go-utils/pkg/logger/handlers/sl"
+)
+
+// Invalidator drops cached orders saved anew by any replica
+type Invalidator interface {
+	Invalidate(context.Context, storage.Invalidation) error
+}
+
+// HandleInvalidations applies invalidations published by every replica, this one included, to the caches
+func HandleInvalidations(ctx context.Context, log *slog.Logger, invCh <-chan storage.Invalidation, cachers ...Invalidator) {
+	const op = "handlers.HandleInvalidations"
+	log = log.With(slog.String("op", op))
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case inv, ok := <-invCh:
+				if !ok {
+					log.Info("closed channel")
+					return
+				}
+				if inv.All {
+					log.Warn("invalidations may have been lost, dropping the whole cache")
+				}
+				for _, cacher := range cachers {
+					if err := cacher.Invalidate(ctx, inv); err != nil {
+						log.Error("failed to invalidate", sl.Err(err), slog.String("order_uid", inv.OrderUID))
+					}
+				}
+			}
+		}
+	}()
+}
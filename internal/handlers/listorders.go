@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"l0/internal/storage"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// OrderLister lists orders page by page
+type OrderLister interface {
+	ListOrders(context.Context, storage.OrderFilter) (*storage.OrderPage, error)
+}
+
+// ListOrdersHandler handles GET /orders with cursor pagination and filters
+func ListOrdersHandler(lister OrderLister) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		f, err := parseOrderFilter(c)
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+
+		page, err := lister.ListOrders(c.Request().Context(), f)
+		if err != nil {
+			if errors.Is(err, storage.ErrInvalidCursor) {
+				return c.String(http.StatusBadRequest, storage.ErrInvalidCursor.Error())
+			}
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		return c.JSON(http.StatusOK, page)
+	}
+}
+
+func parseOrderFilter(c echo.Context) (storage.OrderFilter, error) {
+	f := storage.OrderFilter{
+		CustomerID:      c.QueryParam("customer_id"),
+		DeliveryService: c.QueryParam("delivery_service"),
+		Locale:          c.QueryParam("locale"),
+		Cursor:          c.QueryParam("cursor"),
+		Limit:           defaultPageSize,
+	}
+
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return f, errors.New("limit must be a positive integer")
+		}
+		f.Limit = min(n, maxPageSize)
+	}
+	if v := c.QueryParam("status"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return f, errors.New("status must be an integer")
+		}
+		f.Status = &n
+	}
+	if v := c.QueryParam("date_from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return f, errors.New("date_from must be an RFC 3339 timestamp")
+		}
+		f.CreatedFrom = t
+	}
+	if v := c.QueryParam("date_to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return f, errors.New("date_to must be an RFC 3339 timestamp")
+		}
+		f.CreatedTo = t
+	}
+	return f, nil
+}
@@ -0,0 +1,101 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"l0/internal/handlers"
+	"l0/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLister records the filter it was asked for
+type fakeLister struct {
+	got  *storage.OrderFilter
+	page *storage.OrderPage
+	err  error
+}
+
+func (l *fakeLister) ListOrders(_ context.Context, f storage.OrderFilter) (*storage.OrderPage, error) {
+	l.got = &f
+	return l.page, l.err
+}
+
+// serve runs a handler on a GET request for target and returns the recorded response
+func serve(h echo.HandlerFunc, target string, params ...string) *httptest.ResponseRecorder {
+	return serveRequest(h, httptest.NewRequest(http.MethodGet, target, nil), params...)
+}
+
+// serveRequest runs a handler on req, with path params given as name, value pairs
+func serveRequest(h echo.HandlerFunc, req *http.Request, params ...string) *httptest.ResponseRecorder {
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	for i := 0; i+1 < len(params); i += 2 {
+		c.SetParamNames(append(c.ParamNames(), params[i])...)
+		c.SetParamValues(append(c.ParamValues(), params[i+1])...)
+	}
+	if err := h(c); err != nil {
+		e.HTTPErrorHandler(err, c)
+	}
+	return rec
+}
+
+func TestListOrdersHandler_Filter(t *testing.T) {
+	l := &fakeLister{page: &storage.OrderPage{NextCursor: "next"}}
+	rec := serve(handlers.ListOrdersHandler(l), "/orders?customer_id=c1&delivery_service=meest&locale=en&status=202"+
+		"&date_from=2024-03-01T00:00:00Z&date_to=2024-04-01T00:00:00%2B03:00&cursor=abc&limit=5")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"orders":null,"next_cursor":"next"}`, rec.Body.String())
+
+	status := 202
+	assert.Equal(t, storage.OrderFilter{
+		CustomerID:      "c1",
+		DeliveryService: "meest",
+		Locale:          "en",
+		Status:          &status,
+		CreatedFrom:     time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		CreatedTo:       time.Date(2024, 4, 1, 0, 0, 0, 0, time.FixedZone("", 3*60*60)),
+		Cursor:          "abc",
+		Limit:           5,
+	}, *l.got)
+}
+
+func TestListOrdersHandler_Limit(t *testing.T) {
+	for query, want := range map[string]int{"": 20, "?limit=1": 1, "?limit=100": 100, "?limit=500": 100} {
+		l := &fakeLister{page: &storage.OrderPage{}}
+		rec := serve(handlers.ListOrdersHandler(l), "/orders"+query)
+		require.Equal(t, http.StatusOK, rec.Code, query)
+		assert.Equal(t, want, l.got.Limit, query)
+	}
+}
+
+func TestListOrdersHandler_BadRequest(t *testing.T) {
+	for _, query := range []string{
+		"limit=abc", "limit=0", "limit=-1",
+		"status=shipped",
+		"date_from=2024-03-01", "date_from=yesterday",
+		"date_to=2024-03-01%2010:00:00",
+	} {
+		l := &fakeLister{}
+		rec := serve(handlers.ListOrdersHandler(l), "/orders?"+query)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+		assert.Nil(t, l.got, "%s: the storage isn't asked", query)
+	}
+}
+
+func TestListOrdersHandler_Errors(t *testing.T) {
+	rec := serve(handlers.ListOrdersHandler(&fakeLister{err: fmt.Errorf("op: %w", storage.ErrInvalidCursor)}), "/orders?cursor=bad")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, storage.ErrInvalidCursor.Error(), rec.Body.String())
+
+	rec = serve(handlers.ListOrdersHandler(&fakeLister{err: errors.New("connection reset")}), "/orders")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
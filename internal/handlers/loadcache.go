@@ -2,28 +2,149 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"iter"
 	"l0/internal/models"
+	"l0/internal/storage"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/kxddry/go-utils/pkg/logger/handlers/sl"
 )
 
+// catchUpOverlap widens the catch-up after a restore to cover clock skew
+// and saves that committed a while after they began
+const catchUpOverlap = time.Minute
+
 // Database is an interface for a SQL or NoSQL database
 type Database interface {
 	OrderGetter
-	AllOrders(ctx context.Context) ([]*models.Order, error)
+	RecentOrders(ctx context.Context, limit, batchSize int) iter.Seq2[[]*models.Order, error]
+}
+
+// ChangeFeed finds the orders saved since a point in time
+type ChangeFeed interface {
+	OrdersSavedSince(ctx context.Context, since time.Time, limit, batchSize int) iter.Seq2[[]*models.Order, error]
+}
+
+// Snapshots keep the cache across restarts
+type Snapshots interface {
+	Save() (saved int, err error)
+	Restore() (takenAt time.Time, restored int, err error)
 }
 
-// LoadCache fetches all orders from the database
-func LoadCache(ctx context.Context, cacher Cacher, db Database) error {
+// LoadCache warms the cache up with the most recent limit orders,
+// streaming them from the database batch by batch
+func LoadCache(ctx context.Context, log *slog.Logger, cacher Cacher, db Database, limit, batchSize int) error {
 	const op = "handlers.LoadCache"
+	log = log.With(slog.String("op", op))
+
+	start := time.Now()
+	loaded := 0
+	for batch, err := range db.RecentOrders(ctx, limit, batchSize) {
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if err = cacher.LoadOrders(ctx, batch); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		loaded += len(batch)
+		log.Info("cache warm-up progress", slog.Int("loaded", loaded), slog.Int("limit", limit))
+	}
+	log.Info("cache warm-up finished", slog.Int("loaded", loaded), slog.Duration("took", time.Since(start)))
+	return nil
+}
 
-	orders, err := db.AllOrders(ctx)
-	if err != nil {
-		return fmt.Errorf("%s: %w", op, err)
+// RestorableCache is a cache that can be restored from a snapshot and dropped if the snapshot falls behind
+type RestorableCache interface {
+	Cacher
+	Invalidator
+}
+
+// RestoreCache warms the cache up from a snapshot, then loads only the orders saved since it was taken.
+// Without a snapshot, or with a corrupt or fully expired one, it falls back to LoadCache. So it does
+// if more than limit orders were saved since: the catch-up couldn't refresh every order the snapshot
+// may hold, so the snapshot is dropped rather than served stale.
+func RestoreCache(ctx context.Context, log *slog.Logger, cacher RestorableCache, db Database, feed ChangeFeed, snaps Snapshots, limit, batchSize int) error {
+	const op = "handlers.RestoreCache"
+	opLog := log.With(slog.String("op", op))
+
+	start := time.Now()
+	takenAt, restored, err := snaps.Restore()
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		opLog.Info("no cache snapshot, warming up from the database")
+	case err != nil:
+		opLog.Warn("cache snapshot unusable, warming up from the database", sl.Err(err))
+	case restored == 0:
+		opLog.Info("cache snapshot has expired, warming up from the database", slog.Time("taken_at", takenAt))
+	}
+	if err != nil || restored == 0 {
+		return LoadCache(ctx, log, cacher, db, limit, batchSize)
 	}
 
-	err = cacher.LoadOrders(ctx, orders)
-	if err != nil {
-		return fmt.Errorf("%s: %w", op, err)
+	caughtUp := 0
+	// one more than the limit tells whether there were more
+	for batch, err := range feed.OrdersSavedSince(ctx, takenAt.Add(-catchUpOverlap), limit+1, batchSize) {
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if err = cacher.LoadOrders(ctx, batch); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		caughtUp += len(batch)
+	}
+	if caughtUp > limit {
+		opLog.Warn("too many orders saved since the cache snapshot, warming up from the database",
+			slog.Time("taken_at", takenAt), slog.Int("limit", limit))
+		if err = cacher.Invalidate(ctx, storage.Invalidation{All: true}); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		return LoadCache(ctx, log, cacher, db, limit, batchSize)
 	}
+	opLog.Info("cache restored from snapshot", slog.Time("taken_at", takenAt), slog.Int("restored", restored),
+		slog.Int("caught_up", caughtUp), slog.Duration("took", time.Since(start)))
 	return nil
 }
+
+// SnapshotCache saves a snapshot of the cache every interval once warm is closed, a half warm cache
+// isn't worth keeping, and one last time when ctx is done.
+// The returned channel is closed once it has stopped.
+func SnapshotCache(ctx context.Context, log *slog.Logger, snaps Snapshots, interval time.Duration, warm <-chan struct{}) <-chan struct{} {
+	const op = "handlers.SnapshotCache"
+	log = log.With(slog.String("op", op))
+	save := func() {
+		start := time.Now()
+		saved, err := snaps.Save()
+		if err != nil {
+			log.Error("failed to save cache snapshot", sl.Err(err))
+			return
+		}
+		log.Debug("saved cache snapshot", slog.Int("orders", saved), slog.Duration("took", time.Since(start)))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			return
+		case <-warm:
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				save()
+				return
+			case <-ticker.C:
+				save()
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,103 @@
+package handlers_test
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"l0/internal/handlers"
+	"l0/internal/models"
+	"l0/internal/storage"
+	"l0/internal/storage/cache"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDB has recent orders to warm up from and orders saved since the snapshot to catch up on
+type fakeDB struct {
+	recent, changed []*models.Order
+	since           time.Time
+}
+
+func (db *fakeDB) GetOrder(context.Context, string) (*models.Order, error) {
+	return nil, storage.ErrOrderNotFound
+}
+
+func (db *fakeDB) RecentOrders(context.Context, int, int) iter.Seq2[[]*models.Order, error] {
+	return func(yield func([]*models.Order, error) bool) { yield(db.recent, nil) }
+}
+
+func (db *fakeDB) OrdersSavedSince(_ context.Context, since time.Time, _, _ int) iter.Seq2[[]*models.Order, error] {
+	db.since = since
+	return func(yield func([]*models.Order, error) bool) { yield(db.changed, nil) }
+}
+
+type fakeSnapshots struct {
+	takenAt  time.Time
+	restored int
+	err      error
+}
+
+func (s fakeSnapshots) Save() (int, error) { return 0, nil }
+
+func (s fakeSnapshots) Restore() (time.Time, int, error) { return s.takenAt, s.restored, s.err }
+
+func TestRestoreCache_TooManyChanges(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeDB{
+		recent:  []*models.Order{{OrderUID: "recent"}},
+		changed: []*models.Order{{OrderUID: "changed1"}, {OrderUID: "changed2"}, {OrderUID: "changed3"}},
+	}
+	c := cache.NewCache(time.Minute, 10)
+	defer c.Stop()
+	// as restored from the snapshot, possibly changed since
+	require.NoError(t, c.SaveOrder(ctx, &models.Order{OrderUID: "snapshot"}))
+
+	snaps := fakeSnapshots{takenAt: time.Now().Add(-time.Hour), restored: 1}
+	require.NoError(t, handlers.RestoreCache(ctx, slog.New(slog.DiscardHandler), c, db, db, snaps, 2, 10))
+
+	_, err := c.GetOrder(ctx, "snapshot")
+	assert.ErrorIs(t, err, storage.ErrOrderNotFound, "snapshot dropped")
+	_, err = c.GetOrder(ctx, "recent")
+	assert.NoError(t, err, "full warm-up")
+}
+
+func TestRestoreCache(t *testing.T) {
+	takenAt := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name     string
+		snaps    fakeSnapshots
+		want     string // the order the cache ends up with
+		caughtUp bool
+	}{
+		{"restored", fakeSnapshots{takenAt: takenAt, restored: 5}, "changed", true},
+		{"missing", fakeSnapshots{err: fmt.Errorf("restore: %w", os.ErrNotExist)}, "recent", false},
+		{"corrupt", fakeSnapshots{err: cache.ErrBadSnapshot}, "recent", false},
+		{"expired", fakeSnapshots{takenAt: takenAt}, "recent", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := &fakeDB{recent: []*models.Order{{OrderUID: "recent"}}, changed: []*models.Order{{OrderUID: "changed"}}}
+			c := cache.NewCache(time.Minute, 10)
+			defer c.Stop()
+
+			err := handlers.RestoreCache(ctx, slog.New(slog.DiscardHandler), c, db, db, tt.snaps, 10, 10)
+			require.NoError(t, err)
+
+			_, err = c.GetOrder(ctx, tt.want)
+			assert.NoError(t, err)
+			if tt.caughtUp {
+				assert.True(t, db.since.Before(takenAt), "catch-up overlaps the snapshot")
+				_, err = c.GetOrder(ctx, "recent")
+				assert.ErrorIs(t, err, storage.ErrOrderNotFound, "no full warm-up")
+			} else {
+				assert.True(t, db.since.IsZero(), "no catch-up")
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"l0/internal/models"
+	"l0/internal/storage"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// TrackLookup finds the orders with a track number
+type TrackLookup interface {
+	OrdersByTrack(context.Context, string) ([]*models.Order, error)
+}
+
+// TransactionLookup finds the orders paid by a payment transaction
+type TransactionLookup interface {
+	OrdersByTransaction(context.Context, string) ([]*models.Order, error)
+}
+
+// TrackCacher serves track number lookups from memory
+type TrackCacher interface {
+	TrackLookup
+	LoadByTrack(context.Context, string, []*models.Order) error
+}
+
+// TransactionCacher serves transaction lookups from memory
+type TransactionCacher interface {
+	TransactionLookup
+	LoadByTransaction(context.Context, string, []*models.Order) error
+}
+
+type lookupFunc func(context.Context, string) ([]*models.Order, error)
+
+// OrdersByTrackHandler handles GET /orders/by-track/:track. Several orders may share a track number.
+func OrdersByTrackHandler(db TrackLookup, cacher TrackCacher) echo.HandlerFunc {
+	return lookupHandler("track", "track number", cacher.OrdersByTrack, db.OrdersByTrack, cacher.LoadByTrack)
+}
+
+// OrdersByTransactionHandler handles GET /orders/by-transaction/:tx
+func OrdersByTransactionHandler(db TransactionLookup, cacher TransactionCacher) echo.HandlerFunc {
+	return lookupHandler("tx", "transaction", cacher.OrdersByTransaction, db.OrdersByTransaction, cacher.LoadByTransaction)
+}
+
+func lookupHandler(param, what string, cached, stored lookupFunc,
+	load func(context.Context, string, []*models.Order) error) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+
+		key := c.Param(param)
+		if key == "" {
+			return echo.ErrNotFound
+		}
+
+		if orders, err := cached(ctx, key); err == nil {
+			return c.JSON(http.StatusOK, orders)
+		}
+
+		orders, err := stored(ctx, key)
+		if err != nil {
+			if errors.Is(err, storage.ErrOrderNotFound) {
+				return c.String(http.StatusNotFound, fmt.Sprintf("no orders with %s %s", what, key))
+			}
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		_ = load(ctx, key, orders) // nil always
+		return c.JSON(http.StatusOK, orders)
+	}
+}
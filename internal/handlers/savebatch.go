@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"l0/internal/kafka"
+	"l0/internal/metrics"
+	"l0/internal/storage"
+	"log/slog"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/kxddry/go-utils/pkg/logger/handlers/sl"
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+// BatchSaver saves orders coming from a broker exactly once per message, a batch at a time.
+// SaveMessages returns an error without saving anything if the batch can't be saved as a whole.
+type BatchSaver interface {
+	MessageSaver
+	SaveMessages(context.Context, []storage.Message) error
+}
+
+// HandleSaveBatches is HandleSaves taking up to size messages at once: whatever arrives within wait
+// of a batch's first message joins it. Undecodable and invalid messages go to the DLQ as usual,
+// the rest are saved in a single transaction and the offsets of the whole batch are committed once.
+// What gets committed, skipped or dead-lettered doesn't depend on the batch size.
+// A batch that can't be saved as a whole, e.g. holding a replayed message or hitting a transient
+// error, is saved a message at a time with the rules of HandleSaves, which isolates the bad record.
+// Once ctx is cancelled the batch in flight is still saved and committed;
+// the returned done channel is closed after that.
+func HandleSaveBatches(ctx context.Context, log *slog.Logger, saver BatchSaver, msgCh <-chan kafka.Message, dlq DeadLetterWriter, commit kafka.CommitFunc, v *validator.Validate, retrier Retrier, cacher OrderSaver, size int, wait time.Duration) (<-chan error, <-chan struct{}) {
+	const op = "handler.HandleSaveBatches"
+	s := newSaves(log.With(slog.String("op", op)), saver, dlq, v, retrier, cacher)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			batch, open := collect(ctx, msgCh, size, wait)
+			// finish the current batch even if shutdown has begun
+			if len(batch) > 0 && !s.handleBatch(context.WithoutCancel(ctx), ctx, saver, batch, commit) {
+				return
+			}
+			if !open {
+				if ctx.Err() == nil {
+					s.log.Info("closed channel")
+				}
+				return
+			}
+		}
+	}()
+	return s.errCh, done
+}
+
+// collect waits for a message, then for more until there are size of them or wait has passed.
+// open is false once msgCh is closed or ctx is done, whatever was collected by then is returned.
+func collect(ctx context.Context, msgCh <-chan kafka.Message, size int, wait time.Duration) (batch []kafka.Message, open bool) {
+	select {
+	case <-ctx.Done():
+		return nil, false
+	case msg, ok := <-msgCh:
+		if !ok {
+			return nil, false
+		}
+		batch = append(batch, msg)
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for len(batch) < size {
+		select {
+		case <-ctx.Done():
+			return batch, false
+		case <-timer.C:
+			return batch, true
+		case msg, ok := <-msgCh:
+			if !ok {
+				return batch, false
+			}
+			batch = append(batch, msg)
+		}
+	}
+	return batch, true
+}
+
+// handleBatch saves a batch and commits it. Messages are handled in order, dead letters included,
+// so a batch cut short by shutdown commits the messages before the one interrupted and nothing after
+// it has been dead-lettered. ctx outlives shutdown, stopCtx doesn't; false is returned if shutdown
+// interrupted retries.
+func (s *saves) handleBatch(ctx, stopCtx context.Context, saver BatchSaver, batch []kafka.Message, commit kafka.CommitFunc) bool {
+	metrics.SaveBatchSize.Observe(float64(len(batch)))
+	letters := make(map[int]kafka.DeadLetter)
+	var msgs []storage.Message
+	for i, msg := range batch {
+		if dl, ok := s.check(msg); !ok {
+			letters[i] = dl
+			continue
+		}
+		msgs = append(msgs, storage.Message{Order: &batch[i].Value, Source: msg.Source()})
+	}
+
+	saved := true
+	if len(msgs) > 0 {
+		if err := saver.SaveMessages(ctx, msgs); err == nil {
+			for _, m := range msgs {
+				s.saved(ctx, m.Order)
+			}
+		} else {
+			saved = false
+			metrics.SaveBatchFallbacks.Inc()
+			s.log.Warn("batch not saved as a whole, saving messages one by one", sl.Err(err), slog.Int("size", len(msgs)))
+		}
+	}
+
+	handled := 0
+	for i, msg := range batch {
+		if dl, ok := letters[i]; ok {
+			if !s.deadLetter(ctx, stopCtx, dl) {
+				break
+			}
+		} else if !saved && !s.save(ctx, stopCtx, msg) {
+			break
+		}
+		handled++
+	}
+
+	if handled > 0 {
+		raw := make([]kafkago.Message, handled)
+		for i, msg := range batch[:handled] {
+			raw[i] = msg.Raw
+		}
+		if err := commit(ctx, raw...); err != nil {
+			s.log.Error("failed to commit", sl.Err(err), slog.Int("size", handled))
+			s.report(err)
+		}
+	}
+	return handled == len(batch)
+}
@@ -0,0 +1,283 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"l0/internal/handlers"
+	"l0/internal/kafka"
+	"l0/internal/models"
+	"l0/internal/storage"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func validOrder(uid string) models.Order {
+	return models.Order{
+		OrderUID:    uid,
+		TrackNumber: "WBILMTESTTRACK",
+		Entry:       "WBIL",
+		Delivery: models.Delivery{Name: "Test Testov", Phone: "+9720000000", Zip: "2639809", City: "Kiryat Mozkin",
+			Address: "Ploshad Mira 15", Region: "Kraiot", Email: "test@gmail.com"},
+		Payment: models.Payment{Transaction: uid, Currency: "USD", Provider: "wbpay", Amount: 1817, PaymentDT: 1637907727,
+			Bank: "alpha", DeliveryCost: 1500, GoodsTotal: 317},
+		Items: []models.Item{{ChrtID: 9934930, TrackNumber: "WBILMTESTTRACK", Price: 453, RID: "ab4219087a764ae0btest",
+			Name: "Mascaras", Sale: 30, Size: "0", TotalPrice: 317, NmID: 2389212, Brand: "Vivienne Sabo", Status: models.StatusAccepted}},
+		Locale:          "en",
+		CustomerID:      "test",
+		DeliveryService: "meest",
+		ShardKey:        "9",
+		SmID:            99,
+		DateCreated:     "2021-11-26T06:22:19Z",
+		OofShard:        "1",
+	}
+}
+
+// fakeSaves records what HandleSaveBatches does with messages
+type fakeSaves struct {
+	mu       sync.Mutex
+	batchErr error
+	errs     map[string]error // SaveMessage result by order uid
+	batches  [][]string
+	one      []string // uids passed to SaveMessage
+	cached   []string
+	dlq      []string // classes
+	dlqErr   error    // WriteDeadLetter fails with it if set
+	attempts int      // failed WriteDeadLetter calls
+	commits  [][]int64
+	block    chan struct{} // SaveMessages waits for it if set
+}
+
+func (f *fakeSaves) SaveMessages(_ context.Context, msgs []storage.Message) error {
+	if f.block != nil {
+		<-f.block
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var uids []string
+	for _, m := range msgs {
+		uids = append(uids, m.Order.OrderUID)
+	}
+	f.batches = append(f.batches, uids)
+	return f.batchErr
+}
+
+func (f *fakeSaves) SaveMessage(_ context.Context, o *models.Order, _ storage.Source) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.one = append(f.one, o.OrderUID)
+	return f.errs[o.OrderUID]
+}
+
+func (f *fakeSaves) SaveOrder(_ context.Context, o *models.Order) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cached = append(f.cached, o.OrderUID)
+	return nil
+}
+
+func (f *fakeSaves) WriteDeadLetter(_ context.Context, dl kafka.DeadLetter) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.dlqErr != nil {
+		f.attempts++
+		return f.dlqErr
+	}
+	f.dlq = append(f.dlq, dl.Class)
+	return nil
+}
+
+func (f *fakeSaves) commit(_ context.Context, msgs ...kafkago.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var offsets []int64
+	for _, m := range msgs {
+		offsets = append(offsets, m.Offset)
+	}
+	f.commits = append(f.commits, offsets)
+	return nil
+}
+
+type once struct{}
+
+func (once) Do(_ context.Context, fn func() error) (int, error) { return 1, fn() }
+
+// handleBatches feeds msgs to HandleSaveBatches and waits until the channel is drained
+func handleBatches(t *testing.T, f *fakeSaves, size int, wait time.Duration, msgs ...kafka.Message) {
+	t.Helper()
+	msgCh := make(chan kafka.Message)
+	_, done := handlers.HandleSaveBatches(context.Background(), slog.New(slog.DiscardHandler), f, msgCh, f, f.commit,
+		validator.New(), once{}, f, size, wait)
+	for _, m := range msgs {
+		msgCh <- m
+	}
+	close(msgCh)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("batch handler didn't stop")
+	}
+}
+
+func message(offset int64, o models.Order) kafka.Message {
+	return kafka.Message{Value: o, Raw: kafkago.Message{Topic: "orders", Offset: offset}}
+}
+
+func TestHandleSaveBatches(t *testing.T) {
+	f := &fakeSaves{}
+	invalid := validOrder("c")
+	invalid.Payment.Currency = "usd"
+	handleBatches(t, f, 3, time.Minute,
+		message(0, validOrder("a")),
+		kafka.Message{Raw: kafkago.Message{Offset: 1}, Err: errors.New("undecodable")},
+		message(2, validOrder("b")),
+		message(3, invalid),
+		message(4, validOrder("d")),
+	)
+
+	// the channel closing flushes the second batch
+	assert.Equal(t, [][]string{{"a", "b"}, {"d"}}, f.batches)
+	assert.Empty(t, f.one)
+	assert.Equal(t, []string{"a", "b", "d"}, f.cached)
+	assert.Equal(t, []string{kafka.ClassPoison, kafka.ClassValidation}, f.dlq)
+	assert.Equal(t, [][]int64{{0, 1, 2}, {3, 4}}, f.commits)
+}
+
+func TestHandleSaveBatches_Wait(t *testing.T) {
+	f := &fakeSaves{}
+	msgCh := make(chan kafka.Message)
+	ctx, cancel := context.WithCancel(context.Background())
+	_, done := handlers.HandleSaveBatches(ctx, slog.New(slog.DiscardHandler), f, msgCh, f, f.commit,
+		validator.New(), once{}, f, 100, 10*time.Millisecond)
+
+	msgCh <- message(0, validOrder("a"))
+	require.Eventually(t, func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return len(f.commits) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, [][]string{{"a"}}, f.batches)
+
+	cancel()
+	<-done
+}
+
+func TestHandleSaveBatches_Fallback(t *testing.T) {
+	f := &fakeSaves{
+		batchErr: storage.ErrBatchRejected,
+		errs: map[string]error{
+			"dup":      storage.ErrDuplicateMessage,
+			"conflict": storage.ErrConflictingOrder,
+			"broken":   errors.New("connection reset"),
+		},
+	}
+	handleBatches(t, f, 4, time.Minute,
+		message(0, validOrder("a")),
+		message(1, validOrder("dup")),
+		message(2, validOrder("conflict")),
+		message(3, validOrder("broken")),
+	)
+
+	assert.Equal(t, [][]string{{"a", "dup", "conflict", "broken"}}, f.batches)
+	assert.Equal(t, []string{"a", "dup", "conflict", "broken"}, f.one)
+	// only the order saved one by one reaches the cache, the bad records are told apart
+	assert.Equal(t, []string{"a"}, f.cached)
+	assert.Equal(t, []string{kafka.ClassConflict, kafka.ClassSave}, f.dlq)
+	assert.Equal(t, [][]int64{{0, 1, 2, 3}}, f.commits)
+}
+
+func TestHandleSaveBatches_Shutdown(t *testing.T) {
+	f := &fakeSaves{block: make(chan struct{})}
+	msgCh := make(chan kafka.Message)
+	ctx, cancel := context.WithCancel(context.Background())
+	_, done := handlers.HandleSaveBatches(ctx, slog.New(slog.DiscardHandler), f, msgCh, f, f.commit,
+		validator.New(), once{}, f, 2, time.Minute)
+
+	msgCh <- message(0, validOrder("a"))
+	msgCh <- message(1, validOrder("b"))
+	// the batch in flight is still saved and committed
+	cancel()
+	close(f.block)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("batch handler didn't stop")
+	}
+	assert.Equal(t, [][]string{{"a", "b"}}, f.batches)
+	assert.Equal(t, [][]int64{{0, 1}}, f.commits)
+}
+
+func TestHandleSaves_SameAsBatches(t *testing.T) {
+	invalid := validOrder("x")
+	invalid.Payment.Currency = "usd"
+	msgs := []kafka.Message{
+		message(0, validOrder("a")),
+		message(1, invalid),
+		message(2, validOrder("broken")),
+		message(3, validOrder("dup")),
+		{Raw: kafkago.Message{Offset: 4}, Err: errors.New("undecodable")},
+	}
+	errs := map[string]error{"broken": errors.New("connection reset"), "dup": storage.ErrDuplicateMessage}
+
+	one := &fakeSaves{errs: errs}
+	msgCh := make(chan kafka.Message)
+	_, done := handlers.HandleSaves(context.Background(), slog.New(slog.DiscardHandler), one, msgCh, one, one.commit,
+		validator.New(), once{}, one)
+	for _, m := range msgs {
+		msgCh <- m
+	}
+	close(msgCh)
+	<-done
+
+	batched := &fakeSaves{errs: errs, batchErr: storage.ErrBatchRejected}
+	handleBatches(t, batched, len(msgs), time.Minute, msgs...)
+
+	var committed []int64
+	for _, c := range one.commits {
+		committed = append(committed, c...)
+	}
+	// a message that failed to save is committed once it's in the DLQ, in both modes
+	assert.Equal(t, []int64{0, 1, 2, 3, 4}, committed)
+	assert.Equal(t, [][]int64{committed}, batched.commits)
+	assert.Equal(t, []string{kafka.ClassValidation, kafka.ClassSave, kafka.ClassPoison}, one.dlq)
+	assert.Equal(t, one.dlq, batched.dlq)
+	assert.Equal(t, one.cached, batched.cached)
+}
+
+func TestHandleSaveBatches_DLQDown(t *testing.T) {
+	f := &fakeSaves{dlqErr: errors.New("dlq is down")}
+	invalid := validOrder("x")
+	invalid.Payment.Currency = "usd"
+	msgCh := make(chan kafka.Message)
+	ctx, cancel := context.WithCancel(context.Background())
+	_, done := handlers.HandleSaveBatches(ctx, slog.New(slog.DiscardHandler), f, msgCh, f, f.commit,
+		validator.New(), once{}, f, 4, time.Minute)
+
+	for _, m := range []kafka.Message{
+		message(0, validOrder("a")),
+		message(1, invalid),
+		message(2, validOrder("b")),
+		{Raw: kafkago.Message{Offset: 3}, Err: errors.New("undecodable")},
+	} {
+		msgCh <- m
+	}
+	require.Eventually(t, func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.attempts >= 2 // retried, not dropped
+	}, 5*time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	// nothing is committed past the dead letter that wasn't written, nor is anything after it dead-lettered
+	assert.Equal(t, [][]int64{{0}}, f.commits)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	assert.Empty(t, f.dlq)
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"errors"
 	"l0/internal/kafka"
+	"l0/internal/metrics"
 	"l0/internal/models"
+	"l0/internal/storage"
 	"log/slog"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/kxddry/go-utils/pkg/logger/handlers/sl"
@@ -16,90 +19,205 @@ type OrderSaver interface {
 	SaveOrder(context.Context, *models.Order) error
 }
 
-// Writer can write
-type Writer interface {
-	Write(ctx context.Context, record models.Order) error
+// MessageSaver saves orders coming from a broker exactly once per message
+type MessageSaver interface {
+	SaveMessage(context.Context, *models.Order, storage.Source) error
 }
 
+// DeadLetterWriter sends messages that couldn't be processed to a Dead-Letter Queue
+type DeadLetterWriter interface {
+	WriteDeadLetter(ctx context.Context, dl kafka.DeadLetter) error
+}
+
+// Retrier repeats an operation until it succeeds or the failure is deemed permanent
+type Retrier interface {
+	Do(ctx context.Context, fn func() error) (attempts int, err error)
+}
+
+// dlqBackoff bounds the wait between attempts to write a dead letter
+const (
+	dlqBackoff    = 100 * time.Millisecond
+	dlqMaxBackoff = 5 * time.Second
+)
+
 // HandleSaves saves orders incoming from a Kafka-like message channel,
-// in case of an error sends the order to a Dead-Letter Queue (DLQ)
-func HandleSaves(ctx context.Context, log *slog.Logger, saver OrderSaver, msgCh <-chan kafka.Message, dlq Writer, commit kafka.CommitFunc, v *validator.Validate) <-chan error {
+// retrying transient failures; once retries are exhausted or the error is permanent
+// it sends the order to a Dead-Letter Queue (DLQ).
+// Saved orders are written through to the cache; saver tells other replicas to drop
+// their copies. Replayed messages and stale versions are
+// committed and skipped; conflicting or invalid updates and undecodable messages go to
+// the DLQ and are committed once the DLQ has them. Dead letters are written until they
+// are, so no message is committed past before it's saved, skipped or dead-lettered.
+// Once ctx is cancelled the message in flight is still saved and committed;
+// the returned done channel is closed after that.
+func HandleSaves(ctx context.Context, log *slog.Logger, saver MessageSaver, msgCh <-chan kafka.Message, dlq DeadLetterWriter, commit kafka.CommitFunc, v *validator.Validate, retrier Retrier, cacher OrderSaver) (<-chan error, <-chan struct{}) {
 	const op = "handler.HandleSaves"
-	log = log.With(slog.String("op", op))
-	errCh := make(chan error, 100)
+	s := newSaves(log.With(slog.String("op", op)), saver, dlq, v, retrier, cacher)
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			case msg, ok := <-msgCh:
 				if !ok {
-					log.Info("closed channel")
+					s.log.Info("closed channel")
 					return
 				}
-				o := msg.Value
-				log.Debug("got message", slog.String("uid", o.OrderUID))
-
-				err := v.Struct(o)
-				if err != nil {
-					var errs validator.ValidationErrors
-					if errors.As(err, &errs) {
-						for _, e := range errs {
-							log.Error("validation error", sl.Err(e))
-						}
-					}
-					log.Error("validation failed", sl.Err(err), slog.String("order_uid", o.OrderUID))
-					select {
-					case errCh <- err:
-					default:
-					}
-
-					if err2 := dlq.Write(ctx, o); err2 != nil {
-						log.Error("failed to send invalid order to dlq", sl.Err(err2), slog.String("order_uid", o.OrderUID))
-						select {
-						case errCh <- err2:
-						default:
-						}
-					}
-
-					if err3 := commit(ctx, msg.Raw); err3 != nil {
-						log.Error("failed to commit offset after validation error", sl.Err(err3))
-						select {
-						case errCh <- err3:
-						default:
-						}
-					}
-
-					continue
-				}
-
-				err = saver.SaveOrder(ctx, &o)
-				if err != nil {
-					log.Error("failed to save order", sl.Err(err))
-					if ctx.Err() != nil {
-						return
-					}
-					err2 := dlq.Write(ctx, o)
-					if err2 != nil {
-						log.Error("failed to send to dlq", sl.Err(err2))
-						select {
-						case errCh <- err2:
-						case <-ctx.Done():
-						}
-					}
-					select {
-					case errCh <- err:
-					case <-ctx.Done():
-					}
-					continue
-				}
-				if err := commit(ctx, msg.Raw); err != nil {
-					log.Error("failed to commit", sl.Err(err))
-				} else {
-					log.Debug("saved order", slog.String("uid", o.OrderUID))
+				// finish the current message even if shutdown has begun
+				if !s.handle(context.WithoutCancel(ctx), ctx, msg, commit) {
+					return
 				}
 			}
 		}
 	}()
-	return errCh
+	return s.errCh, done
+}
+
+// saves holds what saving messages takes, for HandleSaves and HandleSaveBatches
+type saves struct {
+	log     *slog.Logger
+	saver   MessageSaver
+	dlq     DeadLetterWriter
+	v       *validator.Validate
+	retrier Retrier
+	cacher  OrderSaver
+	errCh   chan error
+}
+
+func newSaves(log *slog.Logger, saver MessageSaver, dlq DeadLetterWriter, v *validator.Validate, retrier Retrier, cacher OrderSaver) *saves {
+	return &saves{log: log, saver: saver, dlq: dlq, v: v, retrier: retrier, cacher: cacher, errCh: make(chan error, 100)}
+}
+
+// report hands an error over to errCh unless it's full
+func (s *saves) report(err error) {
+	select {
+	case s.errCh <- err:
+	default:
+	}
+}
+
+// handle saves or dead-letters a single message and commits it. ctx outlives shutdown, stopCtx doesn't;
+// false is returned if shutdown interrupted retries, the message is left uncommitted then.
+func (s *saves) handle(ctx, stopCtx context.Context, msg kafka.Message, commit kafka.CommitFunc) bool {
+	if dl, ok := s.check(msg); !ok {
+		if !s.deadLetter(ctx, stopCtx, dl) {
+			return false
+		}
+	} else if !s.save(ctx, stopCtx, msg) {
+		return false
+	}
+	if err := commit(ctx, msg.Raw); err != nil {
+		s.log.Error("failed to commit", sl.Err(err))
+		s.report(err)
+	}
+	return true
+}
+
+// check tells whether a message holds a valid order, or returns the dead letter for it
+func (s *saves) check(msg kafka.Message) (kafka.DeadLetter, bool) {
+	if msg.Err != nil {
+		s.log.Error("poison message", sl.Err(msg.Err),
+			slog.Int("partition", msg.Raw.Partition), slog.Int64("offset", msg.Raw.Offset))
+		s.report(msg.Err)
+		return kafka.DeadLetter{Raw: msg.Raw, Class: kafka.ClassPoison, Err: msg.Err}, false
+	}
+
+	o := msg.Value
+	s.log.Debug("got message", slog.String("uid", o.OrderUID))
+	err := s.v.Struct(o)
+	if err == nil {
+		return kafka.DeadLetter{}, true
+	}
+	metrics.ValidationFailures.Inc()
+	var (
+		errs   validator.ValidationErrors
+		fields []kafka.FieldError
+	)
+	if errors.As(err, &errs) {
+		for _, e := range errs {
+			s.log.Error("validation error", sl.Err(e))
+			fields = append(fields, kafka.FieldError{Field: e.Namespace(), Tag: e.Tag(), Param: e.Param()})
+		}
+	}
+	s.log.Error("validation failed", sl.Err(err), slog.String("order_uid", o.OrderUID))
+	s.report(err)
+	return kafka.DeadLetter{Raw: msg.Raw, Class: kafka.ClassValidation, Err: err, FieldErrors: fields}, false
+}
+
+// deadLetter writes a dead letter, trying again until it's written or stopCtx is done:
+// the message is committed right after, and would be lost if the write was dropped.
+// It reports whether the dead letter was written.
+func (s *saves) deadLetter(ctx, stopCtx context.Context, dl kafka.DeadLetter) bool {
+	for wait := dlqBackoff; ; wait = min(2*wait, dlqMaxBackoff) {
+		err := s.dlq.WriteDeadLetter(ctx, dl)
+		if err == nil {
+			metrics.DLQWrites.WithLabelValues(dl.Class).Inc()
+			return true
+		}
+		s.log.Error("failed to send to dlq", sl.Err(err), slog.String("class", dl.Class),
+			slog.Int("partition", dl.Raw.Partition), slog.Int64("offset", dl.Raw.Offset))
+		s.report(err)
+
+		t := time.NewTimer(wait)
+		select {
+		case <-stopCtx.Done():
+			t.Stop()
+			// leave it uncommitted, it'll be redelivered after restart
+			return false
+		case <-t.C:
+		}
+	}
+}
+
+// save saves a valid order, retrying until stopCtx is done, and dead-letters it if it can't be saved.
+// It reports whether the message may be committed; false if shutdown interrupted retries.
+func (s *saves) save(ctx, stopCtx context.Context, msg kafka.Message) bool {
+	o := msg.Value
+	attempts, err := s.retrier.Do(stopCtx, func() error {
+		return s.saver.SaveMessage(ctx, &o, msg.Source())
+	})
+	if err != nil && stopCtx.Err() != nil && errors.Is(err, stopCtx.Err()) {
+		// leave it uncommitted, it'll be redelivered after restart
+		s.log.Warn("shutdown interrupted retries", sl.Err(err), slog.String("order_uid", o.OrderUID))
+		return false
+	}
+	if errors.Is(err, storage.ErrDuplicateMessage) {
+		metrics.DuplicateMessages.Inc()
+		s.log.Warn("duplicate message skipped", slog.String("order_uid", o.OrderUID),
+			slog.Int("partition", msg.Raw.Partition), slog.Int64("offset", msg.Raw.Offset))
+		return true
+	}
+	if errors.Is(err, storage.ErrStaleOrder) {
+		metrics.StaleMessages.Inc()
+		s.log.Warn("stale order version skipped", slog.String("order_uid", o.OrderUID), slog.Int64("version", o.Version),
+			slog.Int("partition", msg.Raw.Partition), slog.Int64("offset", msg.Raw.Offset))
+		return true
+	}
+	if errors.Is(err, storage.ErrConflictingOrder) || errors.Is(err, storage.ErrInvalidTransition) {
+		s.log.Error("order update rejected", sl.Err(err), slog.String("order_uid", o.OrderUID),
+			slog.Int("partition", msg.Raw.Partition), slog.Int64("offset", msg.Raw.Offset))
+		s.report(err)
+		class := kafka.ClassConflict
+		if errors.Is(err, storage.ErrInvalidTransition) {
+			class = kafka.ClassTransition
+		}
+		return s.deadLetter(ctx, stopCtx, kafka.DeadLetter{Raw: msg.Raw, Class: class, Err: err, Attempts: attempts})
+	}
+	if err != nil {
+		s.log.Error("failed to save order", sl.Err(err), slog.String("order_uid", o.OrderUID), slog.Int("attempts", attempts))
+		s.report(err)
+		return s.deadLetter(ctx, stopCtx, kafka.DeadLetter{Raw: msg.Raw, Class: kafka.ClassSave, Err: err, Attempts: attempts})
+	}
+	s.saved(ctx, &o)
+	return true
+}
+
+// saved writes a saved order through to the cache
+func (s *saves) saved(ctx context.Context, o *models.Order) {
+	metrics.OrdersSaved.Inc()
+	// keep the local cache in line with the database
+	_ = s.cacher.SaveOrder(ctx, o)
+	s.log.Debug("saved order", slog.String("uid", o.OrderUID))
 }
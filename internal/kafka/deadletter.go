@@ -0,0 +1,211 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"iter"
+	"l0/internal/storage"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Error classes of dead letters
+const (
+	ClassValidation = "validation" // order failed validation
+	ClassConflict   = "conflict"   // order re-delivered with the same version and different content
+	ClassTransition = "transition" // update moves an item to a status it can't reach
+	ClassSave       = "save"       // order could not be persisted
+	ClassPoison     = "poison"     // payload could not be decoded
+)
+
+// Headers set on dead letters, next to the original message headers
+const (
+	HeaderErrorClass      = "dlq-error-class"
+	HeaderError           = "dlq-error"
+	HeaderFieldErrors     = "dlq-field-errors"
+	HeaderSourceTopic     = "dlq-source-topic"
+	HeaderSourcePartition = "dlq-source-partition"
+	HeaderSourceOffset    = "dlq-source-offset"
+	HeaderAttempts        = "dlq-attempts"
+	HeaderTimestamp       = "dlq-timestamp"
+)
+
+// FieldError describes a single failed validation rule
+type FieldError struct {
+	Field string `json:"field"`
+	Tag   string `json:"tag"`
+	Param string `json:"param,omitempty"`
+}
+
+// DeadLetter is a message that could not be processed, along with the reason
+type DeadLetter struct {
+	Raw         kafka.Message // the original message, its value is kept byte for byte
+	Class       string
+	Err         error
+	FieldErrors []FieldError
+	Attempts    int
+	Time        time.Time      // when it was dead-lettered, filled in by ParseDeadLetter
+	Letter      storage.Source // where the dead letter itself was read from, filled in by ParseDeadLetter
+}
+
+// WriteDeadLetter writes the original payload with headers describing the failure
+func (w Writer[T]) WriteDeadLetter(ctx context.Context, dl DeadLetter) error {
+	msg, err := EncodeDeadLetter(dl, time.Now())
+	if err != nil {
+		return err
+	}
+	return w.w.WriteMessages(ctx, msg)
+}
+
+// EncodeDeadLetter builds the message WriteDeadLetter sends: the original key, value and headers,
+// followed by headers describing the failure, dead-lettered at now. ParseDeadLetter reverses it.
+func EncodeDeadLetter(dl DeadLetter, now time.Time) (kafka.Message, error) {
+	msg := kafka.Message{
+		Key:     dl.Raw.Key,
+		Value:   dl.Raw.Value,
+		Headers: append([]kafka.Header(nil), dl.Raw.Headers...),
+	}
+	header := func(k, v string) {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+
+	header(HeaderErrorClass, dl.Class)
+	if dl.Err != nil {
+		header(HeaderError, dl.Err.Error())
+	}
+	if len(dl.FieldErrors) > 0 {
+		fe, err := json.Marshal(dl.FieldErrors)
+		if err != nil {
+			return kafka.Message{}, err
+		}
+		header(HeaderFieldErrors, string(fe))
+	}
+	header(HeaderSourceTopic, dl.Raw.Topic)
+	header(HeaderSourcePartition, strconv.Itoa(dl.Raw.Partition))
+	header(HeaderSourceOffset, strconv.FormatInt(dl.Raw.Offset, 10))
+	header(HeaderAttempts, strconv.Itoa(dl.Attempts))
+	header(HeaderTimestamp, now.UTC().Format(time.RFC3339Nano))
+	return msg, nil
+}
+
+// ParseDeadLetter restores a dead letter from a message read off the DLQ topic.
+// Raw then points at the original message: its source topic, partition, offset and headers.
+func ParseDeadLetter(m kafka.Message) DeadLetter {
+	dl := DeadLetter{
+		Raw:    kafka.Message{Key: m.Key, Value: m.Value},
+		Letter: storage.Source{Topic: m.Topic, Partition: m.Partition, Offset: m.Offset},
+	}
+	for _, h := range m.Headers {
+		v := string(h.Value)
+		switch h.Key {
+		case HeaderErrorClass:
+			dl.Class = v
+		case HeaderError:
+			dl.Err = errors.New(v)
+		case HeaderFieldErrors:
+			_ = json.Unmarshal(h.Value, &dl.FieldErrors)
+		case HeaderSourceTopic:
+			dl.Raw.Topic = v
+		case HeaderSourcePartition:
+			dl.Raw.Partition, _ = strconv.Atoi(v)
+		case HeaderSourceOffset:
+			dl.Raw.Offset, _ = strconv.ParseInt(v, 10, 64)
+		case HeaderAttempts:
+			dl.Attempts, _ = strconv.Atoi(v)
+		case HeaderTimestamp:
+			dl.Time, _ = time.Parse(time.RFC3339Nano, v)
+		default:
+			dl.Raw.Headers = append(dl.Raw.Headers, h)
+		}
+	}
+	if dl.Time.IsZero() {
+		dl.Time = m.Time
+	}
+	return dl
+}
+
+// ReadDeadLetters reads every partition of the topic from the beginning up to
+// its end as of the call. It doesn't join a consumer group and commits nothing.
+func ReadDeadLetters(ctx context.Context, brokers []string, topic string) iter.Seq2[DeadLetter, error] {
+	return func(yield func(DeadLetter, error) bool) {
+		partitions, err := readPartitions(ctx, brokers, topic)
+		if err != nil {
+			yield(DeadLetter{}, err)
+			return
+		}
+
+		for _, p := range partitions {
+			if !readPartition(ctx, brokers, p, yield) {
+				return
+			}
+		}
+	}
+}
+
+// readPartitions lists the topic's partitions, asking each broker in turn until one answers
+func readPartitions(ctx context.Context, brokers []string, topic string) ([]kafka.Partition, error) {
+	err := errors.New("no brokers configured")
+	for _, broker := range brokers {
+		var conn *kafka.Conn
+		conn, err = kafka.DialContext(ctx, "tcp", broker)
+		if err != nil {
+			continue
+		}
+		var partitions []kafka.Partition
+		partitions, err = conn.ReadPartitions(topic)
+		_ = conn.Close()
+		if err == nil {
+			return partitions, nil
+		}
+	}
+	return nil, err
+}
+
+// partitionIdle is how long a partition may have nothing to read before it's taken as read to the end.
+// The last offsets before the high-water mark may never come: transaction markers take offsets too,
+// and compaction or retention can remove messages while the partition is being read.
+const partitionIdle = 5 * time.Second
+
+// readPartition yields the partition's messages and reports whether to go on.
+func readPartition(ctx context.Context, brokers []string, p kafka.Partition, yield func(DeadLetter, error) bool) bool {
+	leader := net.JoinHostPort(p.Leader.Host, strconv.Itoa(p.Leader.Port))
+	conn, err := kafka.DialLeader(ctx, "tcp", leader, p.Topic, p.ID)
+	if err != nil {
+		return yield(DeadLetter{}, err)
+	}
+	first, last, err := conn.ReadOffsets()
+	_ = conn.Close()
+	if err != nil {
+		return yield(DeadLetter{}, err)
+	}
+	if first >= last {
+		return true
+	}
+
+	r := kafka.NewReader(kafka.ReaderConfig{Brokers: brokers, Topic: p.Topic, Partition: p.ID})
+	defer func() { _ = r.Close() }()
+	if err := r.SetOffset(first); err != nil {
+		return yield(DeadLetter{}, err)
+	}
+	for {
+		readCtx, cancel := context.WithTimeout(ctx, partitionIdle)
+		m, err := r.ReadMessage(readCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+				return true
+			}
+			return yield(DeadLetter{}, err)
+		}
+		if !yield(ParseDeadLetter(m), nil) {
+			return false
+		}
+		if m.Offset >= last-1 {
+			return true
+		}
+	}
+}
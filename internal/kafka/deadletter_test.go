@@ -0,0 +1,51 @@
+package kafka_test
+
+import (
+	"errors"
+	"l0/internal/kafka"
+	"l0/internal/storage"
+	"testing"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetter_RoundTrip(t *testing.T) {
+	now := time.Date(2026, 10, 18, 9, 30, 0, 123, time.FixedZone("MSK", 3*60*60))
+	dl := kafka.DeadLetter{
+		Raw: kafkago.Message{Topic: "orders", Partition: 3, Offset: 42, Key: []byte("a"), Value: []byte(`{"order_uid":"a"}`),
+			Headers: []kafkago.Header{{Key: "trace-id", Value: []byte("t1")}}},
+		Class:       kafka.ClassValidation,
+		Err:         errors.New("currency: uppercase"),
+		FieldErrors: []kafka.FieldError{{Field: "Order.Payment.Currency", Tag: "uppercase"}, {Field: "Order.Payment.Currency", Tag: "len", Param: "3"}},
+		Attempts:    4,
+	}
+
+	msg, err := kafka.EncodeDeadLetter(dl, now)
+	require.NoError(t, err)
+	assert.Equal(t, dl.Raw.Value, msg.Value, "the payload is kept byte for byte")
+
+	// as read off the DLQ topic
+	msg.Topic, msg.Partition, msg.Offset = "dlq", 1, 7
+	got := kafka.ParseDeadLetter(msg)
+	assert.Equal(t, dl.Raw, got.Raw)
+	assert.Equal(t, dl.Class, got.Class)
+	assert.EqualError(t, got.Err, dl.Err.Error())
+	assert.Equal(t, dl.FieldErrors, got.FieldErrors)
+	assert.Equal(t, dl.Attempts, got.Attempts)
+	assert.True(t, now.Equal(got.Time), "got %v", got.Time)
+	assert.Equal(t, storage.Source{Topic: "dlq", Partition: 1, Offset: 7}, got.Letter)
+}
+
+func TestParseDeadLetter_NoHeaders(t *testing.T) {
+	// written without a failure timestamp: the DLQ message time stands in
+	at := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	got := kafka.ParseDeadLetter(kafkago.Message{Topic: "dlq", Value: []byte("garbage"), Time: at,
+		Headers: []kafkago.Header{{Key: kafka.HeaderErrorClass, Value: []byte(kafka.ClassPoison)}}})
+	assert.Equal(t, kafka.ClassPoison, got.Class)
+	assert.NoError(t, got.Err)
+	assert.Empty(t, got.Raw.Headers)
+	assert.Equal(t, at, got.Time)
+}
@@ -3,8 +3,12 @@ package kafka
 import (
 	c "context"
 	"encoding/json"
+	"fmt"
 	"l0/internal/config"
+	"l0/internal/metrics"
 	"l0/internal/models"
+	"l0/internal/storage"
+	"strconv"
 
 	"github.com/segmentio/kafka-go"
 )
@@ -14,14 +18,22 @@ type Reader struct {
 	r *kafka.Reader
 }
 
-// Message messages
+// Message messages. Err is set when the payload couldn't be decoded,
+// Value is empty then and the message should be dead-lettered.
 type Message struct {
 	Value models.Order
 	Raw   kafka.Message
+	Err   error
 }
 
-// CommitFunc is so tired of creating these useless ass comments
-type CommitFunc func(ctx c.Context, m kafka.Message) error
+// Source tells where the message was read from
+func (m Message) Source() storage.Source {
+	return storage.Source{Topic: m.Raw.Topic, Partition: m.Raw.Partition, Offset: m.Raw.Offset, Time: m.Raw.Time}
+}
+
+// CommitFunc is so tired of creating these useless ass comments.
+// Several messages are committed in one request.
+type CommitFunc func(ctx c.Context, msgs ...kafka.Message) error
 
 // NewReader is, too.
 func NewReader(cfg config.ReaderConfig, brokers []string) Reader {
@@ -36,7 +48,7 @@ func NewReader(cfg config.ReaderConfig, brokers []string) Reader {
 	}
 
 	return Reader{
-		kafka.NewReader(kafka.ReaderConfig{
+		r: kafka.NewReader(kafka.ReaderConfig{
 			Brokers:        brokers,
 			GroupID:        cfg.GroupID,
 			Topic:          cfg.Topic,
@@ -48,12 +60,22 @@ func NewReader(cfg config.ReaderConfig, brokers []string) Reader {
 	}
 }
 
+// CheckAlive checks that the reader's brokers are reachable
+func (r Reader) CheckAlive(ctx c.Context) error {
+	return checkBrokers(ctx, r.r.Config().Brokers)
+}
+
+// Close closes the reader, flushing pending offset commits
+func (r Reader) Close() error {
+	return r.r.Close()
+}
+
 // Messages now
 func (r Reader) Messages(ctx c.Context) (<-chan Message, <-chan error, CommitFunc) {
 	msgCh := make(chan Message)
 	errCh := make(chan error, 1)
-	commit := func(ctx c.Context, m kafka.Message) error {
-		return r.r.CommitMessages(ctx, m)
+	commit := func(ctx c.Context, msgs ...kafka.Message) error {
+		return r.r.CommitMessages(ctx, msgs...)
 	}
 
 	go func() {
@@ -73,17 +95,19 @@ func (r Reader) Messages(ctx c.Context) (<-chan Message, <-chan error, CommitFun
 				return
 			}
 
-			var order models.Order
-			if err := json.Unmarshal(m.Value, &order); err != nil {
-				select {
-				case errCh <- err: // валидация джейсонов
-				case <-ctx.Done():
-				}
-				continue
+			metrics.MessagesConsumed.Inc()
+			metrics.BytesConsumed.Add(float64(len(m.Value)))
+			metrics.ConsumerLag.WithLabelValues(strconv.Itoa(m.Partition)).Set(float64(m.HighWaterMark - m.Offset - 1))
+
+			msg := Message{Raw: m}
+			if err := json.Unmarshal(m.Value, &msg.Value); err != nil {
+				// poison message: hand it over anyway so it gets dead-lettered and committed
+				metrics.PoisonMessages.Inc()
+				msg = Message{Raw: m, Err: fmt.Errorf("decode message: %w", err)}
 			}
 
 			select {
-			case msgCh <- Message{Value: order, Raw: m}:
+			case msgCh <- msg:
 			case <-ctx.Done():
 				return
 			}
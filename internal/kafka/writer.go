@@ -3,6 +3,7 @@ package kafka
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"l0/internal/config"
 	"l0/internal/models"
 	"time"
@@ -13,7 +14,8 @@ import (
 
 // Writer ...
 type Writer[T models.Order] struct {
-	w *kafka.Writer
+	w       *kafka.Writer
+	brokers []string
 }
 
 // Write ...
@@ -28,8 +30,31 @@ func (w Writer[T]) Write(ctx context.Context, record T) error {
 	return w.w.WriteMessages(ctx, msg)
 }
 
+// Republish writes the original message of a dead letter back as it was.
+// Only a writer from NewSyncWriter reports whether it got there.
+func (w Writer[T]) Republish(ctx context.Context, dl DeadLetter) error {
+	return w.w.WriteMessages(ctx, kafka.Message{Key: dl.Raw.Key, Value: dl.Raw.Value, Headers: dl.Raw.Headers})
+}
+
+// Close flushes pending async writes and closes the writer
+func (w Writer[T]) Close() error {
+	return w.w.Close()
+}
+
 // NewWriter ...
 func NewWriter[T models.Order](cfg config.WriterConfig, brokers []string) Writer[T] {
+	return newWriter[T](cfg, brokers, true)
+}
+
+// NewSyncWriter creates a writer whose writes return once every in-sync replica has the message,
+// so their errors reach the caller. Dead letters and replays go through one: the message they
+// carry is committed on the source topic right after, and would be lost if the write failed.
+func NewSyncWriter[T models.Order](cfg config.WriterConfig, brokers []string) Writer[T] {
+	cfg.Acks = "all"
+	return newWriter[T](cfg, brokers, false)
+}
+
+func newWriter[T models.Order](cfg config.WriterConfig, brokers []string, async bool) Writer[T] {
 	var compression kafka.Compression
 
 	switch cfg.Compression {
@@ -63,24 +88,36 @@ func NewWriter[T models.Order](cfg config.WriterConfig, brokers []string) Writer
 		Balancer:               &kafka.RoundRobin{},
 		MaxAttempts:            cfg.Retries,
 		RequiredAcks:           requiredAcks,
-		Async:                  true,
+		Async:                  async,
 		Compression:            compression,
 		WriteTimeout:           cfg.Timeout,
 		AllowAutoTopicCreation: false,
 	}
-	return Writer[T]{w: w}
+	return Writer[T]{w: w, brokers: brokers}
+}
+
+// CheckAlive checks that the writer's brokers are reachable
+func (w Writer[T]) CheckAlive(ctx context.Context) error {
+	return checkBrokers(ctx, w.brokers)
 }
 
-// CheckAlive ...
-func (w Writer[T]) CheckAlive(brokers []string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+// checkBrokers succeeds as soon as any broker answers with the cluster controller
+func checkBrokers(ctx context.Context, brokers []string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*2)
 	defer cancel()
 
-	conn, err := kafka.DialContext(ctx, "tcp", brokers[0])
-	if err != nil {
-		return err
+	err := errors.New("no brokers configured")
+	for _, broker := range brokers {
+		var conn *kafka.Conn
+		conn, err = kafka.DialContext(ctx, "tcp", broker)
+		if err != nil {
+			continue
+		}
+		_, err = conn.Controller()
+		_ = conn.Close()
+		if err == nil {
+			return nil
+		}
 	}
-	defer func() { _ = conn.Close() }()
-	_, err = conn.Controller()
 	return err
 }
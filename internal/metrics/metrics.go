@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "l0"
+
+// Kafka consumer
+var (
+	MessagesConsumed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "kafka", Name: "messages_consumed_total",
+		Help: "Messages fetched from the orders topic.",
+	})
+	BytesConsumed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "kafka", Name: "bytes_consumed_total",
+		Help: "Payload bytes fetched from the orders topic.",
+	})
+	PoisonMessages = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "kafka", Name: "poison_messages_total",
+		Help: "Messages whose payload could not be decoded.",
+	})
+	ConsumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace, Subsystem: "kafka", Name: "consumer_lag",
+		Help: "Messages behind the high watermark, per partition, as of the last fetch.",
+	}, []string{"partition"})
+)
+
+// Ingestion
+var (
+	OrdersSaved = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "ingest", Name: "orders_saved_total",
+		Help: "Orders persisted from the orders topic.",
+	})
+	ValidationFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "ingest", Name: "validation_failures_total",
+		Help: "Orders that failed validation.",
+	})
+	DuplicateMessages = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "ingest", Name: "duplicate_messages_total",
+		Help: "Messages skipped because they had already been applied.",
+	})
+	StaleMessages = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "ingest", Name: "stale_messages_total",
+		Help: "Messages skipped because a newer version of the order is stored.",
+	})
+	DLQWrites = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "ingest", Name: "dlq_writes_total",
+		Help: "Messages sent to the dead-letter queue, by error class.",
+	}, []string{"class"})
+	SaveBatchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace, Subsystem: "ingest", Name: "save_batch_size",
+		Help:    "Messages per batch when saving in batches.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	})
+	SaveBatchFallbacks = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "ingest", Name: "save_batch_fallbacks_total",
+		Help: "Batches that couldn't be saved as a whole and were saved one message at a time.",
+	})
+)
+
+// Cache
+var (
+	CacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "cache", Name: "hits_total",
+		Help: "Cache lookups that found an order.",
+	})
+	CacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "cache", Name: "misses_total",
+		Help: "Cache lookups that found nothing or an expired order.",
+	})
+	CacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "cache", Name: "evictions_total",
+		Help: "Orders evicted to stay within the limit.",
+	})
+	CacheExpirations = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "cache", Name: "expirations_total",
+		Help: "Orders dropped after their TTL.",
+	})
+	CacheSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace, Subsystem: "cache", Name: "size",
+		Help: "Orders currently cached.",
+	})
+	CacheInvalidations = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "cache", Name: "invalidations_total",
+		Help: "Orders dropped because another replica saved a newer version.",
+	})
+	CacheNegativeHits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "cache", Name: "negative_hits_total",
+		Help: "Lookups answered not found because storage recently didn't have the order.",
+	})
+	CoalescedReads = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "cache", Name: "coalesced_reads_total",
+		Help: "Cache misses whose database read was shared with concurrent requests for the same order.",
+	})
+	CacheL2Hits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "cache_l2", Name: "hits_total",
+		Help: "In-memory cache misses found in the second-level cache.",
+	})
+	CacheL2Misses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "cache_l2", Name: "misses_total",
+		Help: "In-memory cache misses the second-level cache didn't have either.",
+	})
+	CacheL2Errors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "cache_l2", Name: "errors_total",
+		Help: "Failed second-level cache calls, reads among them counted as misses.",
+	})
+)
+
+// Storage
+var StorageQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace, Subsystem: "storage", Name: "query_duration_seconds",
+	Help:    "Latency of storage operations.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"op"})
+
+// HTTP
+var HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace, Subsystem: "http", Name: "request_duration_seconds",
+	Help:    "Latency of HTTP requests, per route.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"method", "route", "status"})
+
+// ObserveQuery records the latency of a storage operation, use as defer metrics.ObserveQuery(op, time.Now())
+func ObserveQuery(op string, start time.Time) {
+	StorageQueryDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+}
+
+// Handler serves the metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// HTTPMiddleware records request latency per route template, e.g. /order/:id
+func HTTPMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+
+			status := c.Response().Status
+			if err != nil {
+				status = http.StatusInternalServerError
+				var he *echo.HTTPError
+				if errors.As(err, &he) {
+					status = he.Code
+				}
+			}
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			HTTPRequestDuration.WithLabelValues(c.Request().Method, route, strconv.Itoa(status)).
+				Observe(time.Since(start).Seconds())
+			return err
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package models
 
+import "slices"
+
 // Order is a structure for orders
 type Order struct {
 	OrderUID    string `json:"order_uid" validate:"required,uuid4|alphanum"`
@@ -18,6 +20,16 @@ type Order struct {
 	SmID              int    `json:"sm_id" validate:"required"`
 	DateCreated       string `json:"date_created" validate:"required,datetime=2006-01-02T15:04:05Z"`
 	OofShard          string `json:"oof_shard" validate:"required"`
+
+	// Version orders updates of the same order: only a higher version replaces a stored one
+	Version int64 `json:"version,omitempty" validate:"gte=0"`
+}
+
+// Clone returns a deep copy: Items is the only field sharing memory with the original
+func (o *Order) Clone() *Order {
+	cp := *o
+	cp.Items = slices.Clone(o.Items)
+	return &cp
 }
 
 // Delivery is ...
@@ -0,0 +1,47 @@
+package models
+
+// Item statuses. An item moves forward along
+// created -> accepted -> assembled -> shipped -> delivered, possibly skipping steps;
+// it can be cancelled until it is delivered and returned once shipped.
+// Cancelled and returned are final.
+const (
+	StatusCreated   = 101
+	StatusAccepted  = 202
+	StatusAssembled = 203
+	StatusShipped   = 204
+	StatusDelivered = 205
+	StatusCancelled = 400
+	StatusReturned  = 401
+)
+
+// stage is the position of a status on the forward path
+var stage = map[int]int{
+	StatusCreated:   1,
+	StatusAccepted:  2,
+	StatusAssembled: 3,
+	StatusShipped:   4,
+	StatusDelivered: 5,
+}
+
+// CanTransition tells whether an item may go from one status to another.
+// Statuses outside the state machine may only stay as they are.
+func CanTransition(from, to int) bool {
+	if from == to {
+		return true
+	}
+	if from == StatusCancelled || from == StatusReturned {
+		return false
+	}
+	fromStage, ok := stage[from]
+	if !ok {
+		return false
+	}
+	switch to {
+	case StatusCancelled:
+		return fromStage < stage[StatusDelivered]
+	case StatusReturned:
+		return fromStage >= stage[StatusShipped]
+	}
+	toStage, ok := stage[to]
+	return ok && toStage > fromStage
+}
@@ -0,0 +1,32 @@
+package models_test
+
+import (
+	"l0/internal/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to int
+		want     bool
+	}{
+		{models.StatusAccepted, models.StatusAccepted, true},
+		{models.StatusCreated, models.StatusAccepted, true},
+		{models.StatusCreated, models.StatusShipped, true},
+		{models.StatusShipped, models.StatusAccepted, false},
+		{models.StatusAssembled, models.StatusCancelled, true},
+		{models.StatusDelivered, models.StatusCancelled, false},
+		{models.StatusDelivered, models.StatusReturned, true},
+		{models.StatusAccepted, models.StatusReturned, false},
+		{models.StatusCancelled, models.StatusAccepted, false},
+		{models.StatusReturned, models.StatusDelivered, false},
+		{999, 999, true},
+		{999, models.StatusAccepted, false},
+		{models.StatusAccepted, 999, false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, models.CanTransition(tt.from, tt.to), "%d -> %d", tt.from, tt.to)
+	}
+}
@@ -0,0 +1,67 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"l0/internal/config"
+	"math/rand/v2"
+	"time"
+)
+
+// Policy retries operations with exponential backoff and jitter
+type Policy struct {
+	cfg       config.Retry
+	retryable func(error) bool
+}
+
+// New creates a policy; retryable tells transient errors from permanent ones
+func New(cfg config.Retry, retryable func(error) bool) *Policy {
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+	if cfg.Multiplier < 1 {
+		cfg.Multiplier = 1
+	}
+	if cfg.MaxBackoff < cfg.InitialBackoff {
+		cfg.MaxBackoff = cfg.InitialBackoff
+	}
+	cfg.Jitter = min(max(cfg.Jitter, 0), 1)
+	return &Policy{cfg: cfg, retryable: retryable}
+}
+
+// Do runs fn until it succeeds, fails with a non-retryable error or runs out of attempts.
+// It returns the number of attempts made and the last error. If ctx is cancelled while
+// waiting between attempts, the returned error wraps both ctx.Err() and the last error.
+func (p *Policy) Do(ctx context.Context, fn func() error) (int, error) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil {
+			return attempt, nil
+		}
+		if attempt >= p.cfg.MaxAttempts || !p.retryable(err) {
+			return attempt, err
+		}
+
+		t := time.NewTimer(p.Backoff(attempt))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return attempt, errors.Join(ctx.Err(), err)
+		case <-t.C:
+		}
+	}
+}
+
+// Backoff returns the delay after the given failed attempt, starting from 1
+func (p *Policy) Backoff(attempt int) time.Duration {
+	d := float64(p.cfg.InitialBackoff)
+	for i := 1; i < attempt && d < float64(p.cfg.MaxBackoff); i++ {
+		d *= p.cfg.Multiplier
+	}
+	d = min(d, float64(p.cfg.MaxBackoff))
+	if p.cfg.Jitter > 0 {
+		// spread uniformly over [d*(1-jitter), d*(1+jitter)]
+		d += d * p.cfg.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
@@ -0,0 +1,73 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"l0/internal/config"
+	"l0/internal/retry"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	errTransient = errors.New("connection refused")
+	errPermanent = errors.New("unique violation")
+)
+
+func isTransient(err error) bool { return errors.Is(err, errTransient) }
+
+func newPolicy(attempts int) *retry.Policy {
+	return retry.New(config.Retry{
+		MaxAttempts:    attempts,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     4 * time.Millisecond,
+		Multiplier:     2,
+	}, isTransient)
+}
+
+func TestPolicy_SucceedsAfterTransientErrors(t *testing.T) {
+	calls := 0
+	attempts, err := newPolicy(5).Do(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return errTransient
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestPolicy_StopsOnPermanentError(t *testing.T) {
+	attempts, err := newPolicy(5).Do(context.Background(), func() error { return errPermanent })
+	assert.ErrorIs(t, err, errPermanent)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestPolicy_ExhaustsAttempts(t *testing.T) {
+	attempts, err := newPolicy(3).Do(context.Background(), func() error { return errTransient })
+	assert.ErrorIs(t, err, errTransient)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestPolicy_CancelledWhileWaiting(t *testing.T) {
+	p := retry.New(config.Retry{MaxAttempts: 5, InitialBackoff: time.Hour, MaxBackoff: time.Hour}, isTransient)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	attempts, err := p.Do(ctx, func() error { return errTransient })
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, err, errTransient)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestPolicy_BackoffIsCapped(t *testing.T) {
+	p := newPolicy(10)
+	assert.Equal(t, time.Millisecond, p.Backoff(1))
+	assert.Equal(t, 2*time.Millisecond, p.Backoff(2))
+	assert.Equal(t, 4*time.Millisecond, p.Backoff(3))
+	assert.Equal(t, 4*time.Millisecond, p.Backoff(8))
+}
@@ -1,39 +1,101 @@
 package cache
 
 import (
-	"container/list"
 	c "context"
+	"fmt"
+	"l0/internal/config"
+	"l0/internal/metrics"
 	"l0/internal/models"
 	"l0/internal/storage"
+	"l0/internal/storage/redis"
 	"sync"
 	"time"
 )
 
+// cacheEntry owns its order: orders are copied on the way in and out,
+// so callers may modify what they saved or got without racing other readers
 type cacheEntry struct {
-	order   models.Order
-	time    time.Time
-	lruElem *list.Element
+	order models.Order
+	time  time.Time
 }
 
-// Cache uses TTL + LRU for cache invalidation.
+// Cache uses TTL + an eviction policy (LRU by default) for cache invalidation.
+// Orders can also be looked up by track number and payment transaction.
 type Cache struct {
 	mp       map[string]*cacheEntry
 	mu       *sync.Mutex
 	ttl      time.Duration
 	stopChan chan struct{}
-	limit    int
-	lru      *list.List
+	policy   policy
+	idx      indexes
 }
 
-// NewCache creates cache
+// Interface is implemented by every cache in this package
+type Interface interface {
+	GetOrder(c.Context, string) (*models.Order, error)
+	SaveOrder(c.Context, *models.Order) error
+	LoadOrders(c.Context, []*models.Order) error
+	OrdersByTrack(c.Context, string) ([]*models.Order, error)
+	OrdersByTransaction(c.Context, string) ([]*models.Order, error)
+	LoadByTrack(c.Context, string, []*models.Order) error
+	LoadByTransaction(c.Context, string, []*models.Order) error
+	Invalidate(c.Context, storage.Invalidation) error
+	Stop()
+
+	snapshot() snapshot
+	restore(snapshot) int
+}
+
+// NewCache creates an LRU cache
 func NewCache(ttl time.Duration, limit int) *Cache {
+	return newCache(ttl, newLRU(limit))
+}
+
+// Second-level caches selectable with config.Cache.L2
+const (
+	L2None  = ""
+	L2Redis = "redis"
+)
+
+// New creates a cache with the configured eviction policy, or a sharded one if cfg.Shards is set,
+// backed by the configured second level if any
+func New(cfg config.Cache) (Interface, error) {
+	l1, err := newL1(cfg)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.L2 {
+	case L2None:
+		return l1, nil
+	case L2Redis:
+		return NewTiered(l1, redis.New(cfg.Redis)), nil
+	}
+	l1.Stop()
+	return nil, fmt.Errorf("unknown second-level cache %q", cfg.L2)
+}
+
+func newL1(cfg config.Cache) (Interface, error) {
+	if cfg.Shards > 0 {
+		if cfg.Policy != "" && cfg.Policy != PolicyLRU {
+			return nil, fmt.Errorf("a sharded cache only supports approximate %s, not %s", PolicyLRU, cfg.Policy)
+		}
+		return NewSharded(cfg.TTL, cfg.Limit, cfg.Shards), nil
+	}
+	p, err := newPolicy(cfg.Policy, cfg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	return newCache(cfg.TTL, p), nil
+}
+
+func newCache(ttl time.Duration, p policy) *Cache {
 	cc := &Cache{
 		mp:       make(map[string]*cacheEntry),
 		mu:       new(sync.Mutex),
 		ttl:      ttl,
 		stopChan: make(chan struct{}),
-		limit:    limit,
-		lru:      list.New(),
+		policy:   p,
+		idx:      newIndexes(),
 	}
 
 	go cc.removeExpired()
@@ -44,35 +106,34 @@ func NewCache(ttl time.Duration, limit int) *Cache {
 func (c *Cache) SaveOrder(ctx c.Context, order *models.Order) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.save(order, time.Now())
+	return nil
+}
 
+// save caches an order saved at a given time, which is when its TTL starts
+func (c *Cache) save(order *models.Order, at time.Time) {
 	if entry, ok := c.mp[order.OrderUID]; ok {
-		entry.order = *order
-		entry.time = time.Now()
-		c.lru.MoveToFront(entry.lruElem)
-		return nil
+		if entry.order.Version > order.Version {
+			return // never replace a newer version
+		}
+		c.idx.relink(&entry.order, order)
+		entry.order = *order.Clone()
+		entry.time = at
+		c.policy.hit(order.OrderUID)
+		return
 	}
 
-	elem := c.lru.PushFront(order.OrderUID)
 	c.mp[order.OrderUID] = &cacheEntry{
-		order:   *order,
-		time:    time.Now(),
-		lruElem: elem,
+		order: *order.Clone(),
+		time:  at,
 	}
+	c.idx.link(order)
 
-	if c.lru.Len() > c.limit {
-		c.removeLRU()
+	for _, victim := range c.policy.add(order.OrderUID) {
+		c.remove(victim)
+		metrics.CacheEvictions.Inc()
 	}
-
-	return nil
-}
-
-func (c *Cache) removeLRU() {
-	back := c.lru.Back()
-	if back == nil {
-		return
-	}
-	orderID := back.Value.(string)
-	c.remove(orderID)
+	metrics.CacheSize.Set(float64(len(c.mp)))
 }
 
 func (c *Cache) remove(orderID string) {
@@ -80,8 +141,10 @@ func (c *Cache) remove(orderID string) {
 	if !ok {
 		return
 	}
-	c.lru.Remove(entry.lruElem)
+	c.policy.remove(orderID)
+	c.idx.unlink(&entry.order)
 	delete(c.mp, orderID)
+	metrics.CacheSize.Set(float64(len(c.mp)))
 }
 
 // GetOrder gets an order from the cache
@@ -91,18 +154,22 @@ func (c *Cache) GetOrder(ctx c.Context, orderID string) (*models.Order, error) {
 
 	entry, ok := c.mp[orderID]
 	if !ok {
+		metrics.CacheMisses.Inc()
 		return nil, storage.ErrOrderNotFound
 	}
 
 	// cache invalidation
 	if time.Since(entry.time) > c.ttl {
 		c.remove(orderID)
+		metrics.CacheExpirations.Inc()
+		metrics.CacheMisses.Inc()
 		return nil, storage.ErrOrderNotFound
 	}
 
-	c.lru.MoveToFront(entry.lruElem)
+	c.policy.hit(orderID)
+	metrics.CacheHits.Inc()
 
-	return &entry.order, nil
+	return entry.order.Clone(), nil
 }
 
 // LoadOrders loads orders provided
@@ -116,6 +183,31 @@ func (c *Cache) LoadOrders(ctx c.Context, orders []*models.Order) error {
 	return nil
 }
 
+// Invalidate drops a cached order older than the one saved, wherever it was saved.
+// A lookup read from the database just before the save may still complete a key without
+// the new order, the TTL bounds how long that lasts.
+func (c *Cache) Invalidate(ctx c.Context, inv storage.Invalidation) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if inv.All {
+		for id := range c.mp {
+			c.remove(id)
+			metrics.CacheInvalidations.Inc()
+		}
+		return nil
+	}
+	if entry, ok := c.mp[inv.OrderUID]; ok {
+		if !inv.Supersedes(entry.order.Version) {
+			return nil // saved here, or already caught up
+		}
+		c.remove(inv.OrderUID)
+		metrics.CacheInvalidations.Inc()
+	}
+	c.idx.invalidate(inv)
+	return nil
+}
+
 func (c *Cache) removeExpired() {
 	ticker := time.NewTicker(time.Minute)
 	defer ticker.Stop()
@@ -128,6 +220,7 @@ func (c *Cache) removeExpired() {
 			for id, entry := range c.mp {
 				if now.Sub(entry.time) > c.ttl {
 					c.remove(id)
+					metrics.CacheExpirations.Inc()
 				}
 			}
 			c.mu.Unlock()
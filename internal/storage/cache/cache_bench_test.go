@@ -0,0 +1,78 @@
+package cache_test
+
+import (
+	"context"
+	"fmt"
+	"l0/internal/config"
+	"l0/internal/storage/cache"
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+const benchOrders = 10_000
+
+// benchVariants compares the single-lock cache with the sharded one
+var benchVariants = []struct {
+	name string
+	cfg  config.Cache
+}{
+	{"lru", config.Cache{Policy: cache.PolicyLRU}},
+	{"tinylfu", config.Cache{Policy: cache.PolicyTinyLFU}},
+	{"sharded-16", config.Cache{Shards: 16}},
+	{"sharded-64", config.Cache{Shards: 64}},
+}
+
+func benchCache(b *testing.B, cfg config.Cache) (cache.Interface, []string) {
+	cfg.TTL, cfg.Limit = time.Hour, benchOrders
+	c, err := cache.New(cfg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(c.Stop)
+
+	ids := make([]string, benchOrders)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("order-%d", i)
+		_ = c.SaveOrder(context.Background(), newTestOrder(ids[i]))
+	}
+	return c, ids
+}
+
+func BenchmarkGetOrder_Parallel(b *testing.B) {
+	for _, v := range benchVariants {
+		b.Run(v.name, func(b *testing.B) {
+			c, ids := benchCache(b, v.cfg)
+			ctx := context.Background()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_, _ = c.GetOrder(ctx, ids[rand.IntN(len(ids))])
+				}
+			})
+		})
+	}
+}
+
+// 90% reads, 10% writes of which half are new orders that force evictions
+func BenchmarkMixed_Parallel(b *testing.B) {
+	for _, v := range benchVariants {
+		b.Run(v.name, func(b *testing.B) {
+			c, ids := benchCache(b, v.cfg)
+			ctx := context.Background()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					switch n := rand.IntN(100); {
+					case n < 90:
+						_, _ = c.GetOrder(ctx, ids[rand.IntN(len(ids))])
+					case n < 95:
+						_ = c.SaveOrder(ctx, newTestOrder(ids[rand.IntN(len(ids))]))
+					default:
+						_ = c.SaveOrder(ctx, newTestOrder(fmt.Sprintf("new-%d", rand.Int())))
+					}
+				}
+			})
+		})
+	}
+}
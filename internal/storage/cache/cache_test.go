@@ -2,7 +2,11 @@ package cache_test
 
 import (
 	"context"
+	"fmt"
+	"l0/internal/config"
+	"l0/internal/handlers"
 	"l0/internal/storage/cache"
+	"sync"
 	"testing"
 	"time"
 
@@ -32,44 +36,80 @@ func newTestOrder(id string) *models.Order {
 	}
 }
 
-func TestCache_SaveAndGetOrder(t *testing.T) {
-	c := cache.NewCache(5*time.Minute, 10)
-	defer c.Stop()
+var (
+	_ handlers.Cacher            = (*cache.Cache)(nil)
+	_ handlers.TrackCacher       = (*cache.Cache)(nil)
+	_ handlers.TransactionCacher = (*cache.Cache)(nil)
+	_ handlers.Cacher            = (*cache.Sharded)(nil)
+	_ handlers.TrackCacher       = (*cache.Sharded)(nil)
+	_ handlers.TransactionCacher = (*cache.Sharded)(nil)
+	_ handlers.Invalidator       = (*cache.Cache)(nil)
+	_ handlers.Invalidator       = (*cache.Sharded)(nil)
+)
 
-	ctx := context.Background()
-	order := newTestOrder("123")
+// variants are the caches every shared test runs against
+var variants = map[string]config.Cache{
+	cache.PolicyLRU:     {Policy: cache.PolicyLRU},
+	cache.PolicyLFU:     {Policy: cache.PolicyLFU},
+	cache.PolicyTinyLFU: {Policy: cache.PolicyTinyLFU},
+	"sharded":           {Shards: 4},
+}
 
-	err := c.SaveOrder(ctx, order)
-	require.NoError(t, err)
+// forEachPolicy runs a test against a fresh cache of every variant
+func forEachPolicy(t *testing.T, ttl time.Duration, limit int, test func(t *testing.T, c cache.Interface)) {
+	for name, cfg := range variants {
+		t.Run(name, func(t *testing.T) {
+			cfg.TTL, cfg.Limit = ttl, limit
+			c, err := cache.New(cfg)
+			require.NoError(t, err)
+			defer c.Stop()
+			test(t, c)
+		})
+	}
+}
 
-	got, err := c.GetOrder(ctx, "123")
-	require.NoError(t, err)
-	assert.Equal(t, order.OrderUID, got.OrderUID)
+func TestNew_UnknownPolicy(t *testing.T) {
+	_, err := cache.New(config.Cache{TTL: time.Minute, Limit: 10, Policy: "fifo"})
+	assert.Error(t, err)
+	_, err = cache.New(config.Cache{TTL: time.Minute, Limit: 10, Policy: cache.PolicyLFU, Shards: 4})
+	assert.Error(t, err)
 }
 
-func TestCache_GetOrder_NotFound(t *testing.T) {
-	c := cache.NewCache(5*time.Minute, 10)
-	defer c.Stop()
+func TestCache_SaveAndGetOrder(t *testing.T) {
+	forEachPolicy(t, 5*time.Minute, 10, func(t *testing.T, c cache.Interface) {
+		ctx := context.Background()
+		order := newTestOrder("123")
 
-	ctx := context.Background()
-	_, err := c.GetOrder(ctx, "nonexistent")
-	assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+		err := c.SaveOrder(ctx, order)
+		require.NoError(t, err)
+
+		got, err := c.GetOrder(ctx, "123")
+		require.NoError(t, err)
+		assert.Equal(t, order.OrderUID, got.OrderUID)
+	})
 }
 
-func TestCache_TTLExpiration(t *testing.T) {
-	c := cache.NewCache(10*time.Millisecond, 10)
-	defer c.Stop()
+func TestCache_GetOrder_NotFound(t *testing.T) {
+	forEachPolicy(t, 5*time.Minute, 10, func(t *testing.T, c cache.Interface) {
+		ctx := context.Background()
+		_, err := c.GetOrder(ctx, "nonexistent")
+		assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+	})
+}
 
-	ctx := context.Background()
-	order := newTestOrder("expire")
+func TestCache_TTLExpiration(t *testing.T) {
+	forEachPolicy(t, 10*time.Millisecond, 10, func(t *testing.T, c cache.Interface) {
+		ctx := context.Background()
+		order := newTestOrder("expire")
 
-	err := c.SaveOrder(ctx, order)
-	require.NoError(t, err)
+		err := c.SaveOrder(ctx, order)
+		require.NoError(t, err)
 
-	time.Sleep(20 * time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
 
-	_, err = c.GetOrder(ctx, "expire")
-	assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+		_, err = c.GetOrder(ctx, "expire")
+		assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+	})
 }
 
 func TestCache_LRUEviction(t *testing.T) {
@@ -104,23 +144,359 @@ func TestCache_LRUEviction(t *testing.T) {
 }
 
 func TestCache_LoadOrders(t *testing.T) {
-	c := cache.NewCache(5*time.Minute, 10)
+	forEachPolicy(t, 5*time.Minute, 10, func(t *testing.T, c cache.Interface) {
+		ctx := context.Background()
+
+		orders := []*models.Order{
+			newTestOrder("a"),
+			newTestOrder("b"),
+			newTestOrder("c"),
+		}
+
+		err := c.LoadOrders(ctx, orders)
+		require.NoError(t, err)
+
+		for _, o := range orders {
+			got, err := c.GetOrder(ctx, o.OrderUID)
+			require.NoError(t, err)
+			assert.Equal(t, o.OrderUID, got.OrderUID)
+		}
+	})
+}
+
+func TestCache_SaveOrder_KeepsNewerVersion(t *testing.T) {
+	forEachPolicy(t, 5*time.Minute, 10, func(t *testing.T, c cache.Interface) {
+		ctx := context.Background()
+		newer := newTestOrder("v")
+		newer.Version = 2
+		older := newTestOrder("v")
+		older.Version = 1
+		older.TrackNumber = "outdated"
+
+		require.NoError(t, c.SaveOrder(ctx, newer))
+		require.NoError(t, c.SaveOrder(ctx, older))
+
+		got, err := c.GetOrder(ctx, "v")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), got.Version)
+		assert.Equal(t, newer.TrackNumber, got.TrackNumber)
+	})
+}
+
+func TestCache_OrdersByTrack(t *testing.T) {
+	forEachPolicy(t, 5*time.Minute, 10, func(t *testing.T, c cache.Interface) {
+		ctx := context.Background()
+		a, b := newTestOrder("a"), newTestOrder("b")
+		a.TrackNumber, b.TrackNumber = "WB1", "WB1"
+		a.DateCreated, b.DateCreated = "2024-01-01T00:00:00Z", "2024-02-01T00:00:00Z"
+
+		// orders cached one by one don't prove nobody else has the track number
+		require.NoError(t, c.SaveOrder(ctx, a))
+		_, err := c.OrdersByTrack(ctx, "WB1")
+		assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+
+		require.NoError(t, c.LoadByTrack(ctx, "WB1", []*models.Order{a, b}))
+		got, err := c.OrdersByTrack(ctx, "WB1")
+		require.NoError(t, err)
+		require.Len(t, got, 2)
+		assert.Equal(t, "b", got[0].OrderUID) // newest first
+		assert.Equal(t, "a", got[1].OrderUID)
+
+		// an order moving to another track number leaves the rest complete
+		moved := newTestOrder("b")
+		moved.TrackNumber, moved.Version = "WB2", 1
+		require.NoError(t, c.SaveOrder(ctx, moved))
+		got, err = c.OrdersByTrack(ctx, "WB1")
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		assert.Equal(t, "a", got[0].OrderUID)
+	})
+}
+
+func TestCache_OrdersByTrack_IncompleteAfterEviction(t *testing.T) {
+	c := cache.NewCache(5*time.Minute, 2)
 	defer c.Stop()
 
 	ctx := context.Background()
+	a, b := newTestOrder("a"), newTestOrder("b")
+	a.TrackNumber, b.TrackNumber = "WB1", "WB1"
+	require.NoError(t, c.LoadByTrack(ctx, "WB1", []*models.Order{a, b}))
+	_, err := c.OrdersByTrack(ctx, "WB1")
+	require.NoError(t, err)
 
-	orders := []*models.Order{
-		newTestOrder("a"),
-		newTestOrder("b"),
-		newTestOrder("c"),
-	}
+	require.NoError(t, c.SaveOrder(ctx, newTestOrder("x"))) // evicts a
+	_, err = c.OrdersByTrack(ctx, "WB1")
+	assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+}
+
+func TestCache_OrdersByTransaction(t *testing.T) {
+	forEachPolicy(t, 5*time.Minute, 10, func(t *testing.T, c cache.Interface) {
+		ctx := context.Background()
+		o := newTestOrder("p")
+		o.Payment.Transaction = "tx1"
+		require.NoError(t, c.LoadByTransaction(ctx, "tx1", []*models.Order{o}))
+
+		got, err := c.OrdersByTransaction(ctx, "tx1")
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		assert.Equal(t, "p", got[0].OrderUID)
+
+		_, err = c.OrdersByTransaction(ctx, "tx2")
+		assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+	})
+}
+
+func withItem(o *models.Order) *models.Order {
+	o.Items = []models.Item{{ChrtID: 1, TrackNumber: o.TrackNumber, Name: "Mascaras", NmID: 2389212}}
+	return o
+}
+
+func TestCache_SaveOrder_CopiesOrder(t *testing.T) {
+	forEachPolicy(t, 5*time.Minute, 10, func(t *testing.T, c cache.Interface) {
+		ctx := context.Background()
+		o := withItem(newTestOrder("s"))
+		require.NoError(t, c.SaveOrder(ctx, o))
+
+		o.TrackNumber = "changed"
+		o.Items[0].Name = "changed"
+		o.Items = append(o.Items, models.Item{Name: "extra"})
+
+		got, err := c.GetOrder(ctx, "s")
+		require.NoError(t, err)
+		assert.Equal(t, "im alive!!!", got.TrackNumber)
+		require.Len(t, got.Items, 1)
+		assert.Equal(t, "Mascaras", got.Items[0].Name)
+	})
+}
+
+func TestCache_GetOrder_ReturnsCopy(t *testing.T) {
+	forEachPolicy(t, 5*time.Minute, 10, func(t *testing.T, c cache.Interface) {
+		ctx := context.Background()
+		o := withItem(newTestOrder("g"))
+		o.Payment.Transaction = "tx"
+		require.NoError(t, c.LoadByTransaction(ctx, "tx", []*models.Order{o}))
+
+		got, err := c.GetOrder(ctx, "g")
+		require.NoError(t, err)
+		got.Items[0].Name = "changed"
+		got.Payment.Transaction = "changed"
+
+		found, err := c.OrdersByTransaction(ctx, "tx")
+		require.NoError(t, err)
+		require.Len(t, found, 1)
+		found[0].Items[0].Name = "changed too"
+
+		got, err = c.GetOrder(ctx, "g")
+		require.NoError(t, err)
+		assert.Equal(t, "Mascaras", got.Items[0].Name)
+		assert.Equal(t, "tx", got.Payment.Transaction)
+	})
+}
+
+// TestCache_ConcurrentReadersIsolated is meant for -race: readers modify what they get while
+// a writer replaces the order, none of them may touch memory another one sees
+func TestCache_ConcurrentReadersIsolated(t *testing.T) {
+	forEachPolicy(t, 5*time.Minute, 10, func(t *testing.T, c cache.Interface) {
+		ctx := context.Background()
+		o := withItem(newTestOrder("r"))
+		o.Payment.Transaction = "tx"
+		require.NoError(t, c.LoadByTransaction(ctx, "tx", []*models.Order{o}))
+
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 200 {
+					got, err := c.GetOrder(ctx, "r")
+					if !assert.NoError(t, err) {
+						return
+					}
+					assert.Equal(t, "Mascaras", got.Items[0].Name)
+					got.Items[0].Name = "changed"
+					got.Items = append(got.Items, models.Item{})
+
+					if found, err := c.OrdersByTransaction(ctx, "tx"); err == nil {
+						found[0].Items[0].Name = "changed"
+					}
+				}
+			}()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for v := range int64(200) {
+				next := withItem(newTestOrder("r"))
+				next.Payment.Transaction, next.Version = "tx", v+1
+				assert.NoError(t, c.SaveOrder(ctx, next))
+				next.Items[0].Name = "changed"
+			}
+		}()
+		wg.Wait()
+
+		got, err := c.GetOrder(ctx, "r")
+		require.NoError(t, err)
+		assert.Equal(t, int64(200), got.Version)
+		assert.Equal(t, []models.Item{withItem(newTestOrder("r")).Items[0]}, got.Items)
+	})
+}
+
+func TestCache_Invalidate(t *testing.T) {
+	forEachPolicy(t, 5*time.Minute, 10, func(t *testing.T, c cache.Interface) {
+		ctx := context.Background()
+		o := newTestOrder("i")
+		o.Version = 1
+		require.NoError(t, c.LoadByTrack(ctx, o.TrackNumber, []*models.Order{o}))
+
+		// its own save coming back changes nothing
+		require.NoError(t, c.Invalidate(ctx, storage.Invalidation{OrderUID: "i", Version: 1, TrackNumber: o.TrackNumber}))
+		_, err := c.GetOrder(ctx, "i")
+		require.NoError(t, err)
+		_, err = c.OrdersByTrack(ctx, o.TrackNumber)
+		require.NoError(t, err)
+
+		// a new order elsewhere joins the track number
+		require.NoError(t, c.Invalidate(ctx, storage.Invalidation{OrderUID: "other", TrackNumber: o.TrackNumber}))
+		_, err = c.GetOrder(ctx, "i")
+		require.NoError(t, err)
+		_, err = c.OrdersByTrack(ctx, o.TrackNumber)
+		assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+
+		// a newer version elsewhere
+		require.NoError(t, c.Invalidate(ctx, storage.Invalidation{OrderUID: "i", Version: 2}))
+		_, err = c.GetOrder(ctx, "i")
+		assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+	})
+}
+
+func TestCache_Invalidate_Unversioned(t *testing.T) {
+	forEachPolicy(t, 5*time.Minute, 10, func(t *testing.T, c cache.Interface) {
+		ctx := context.Background()
+		require.NoError(t, c.SaveOrder(ctx, newTestOrder("u")))
+
+		// updates without a version are ordered by event time, not comparable here: the copy goes
+		require.NoError(t, c.Invalidate(ctx, storage.Invalidation{OrderUID: "u"}))
+		_, err := c.GetOrder(ctx, "u")
+		assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+	})
+}
+
+func TestCache_Invalidate_All(t *testing.T) {
+	forEachPolicy(t, 5*time.Minute, 10, func(t *testing.T, c cache.Interface) {
+		ctx := context.Background()
+		a, b := newTestOrder("a"), newTestOrder("b")
+		require.NoError(t, c.LoadByTrack(ctx, a.TrackNumber, []*models.Order{a, b}))
+
+		require.NoError(t, c.Invalidate(ctx, storage.Invalidation{All: true}))
+		for _, id := range []string{"a", "b"} {
+			_, err := c.GetOrder(ctx, id)
+			assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+		}
+		_, err := c.OrdersByTrack(ctx, a.TrackNumber)
+		assert.ErrorIs(t, err, storage.ErrOrderNotFound)
 
-	err := c.LoadOrders(ctx, orders)
+		require.NoError(t, c.SaveOrder(ctx, a)) // still usable
+		_, err = c.GetOrder(ctx, "a")
+		assert.NoError(t, err)
+	})
+}
+
+func TestCache_LFUEviction(t *testing.T) {
+	c, err := cache.New(config.Cache{TTL: 5 * time.Minute, Limit: 3, Policy: cache.PolicyLFU})
 	require.NoError(t, err)
+	defer c.Stop()
 
-	for _, o := range orders {
-		got, err := c.GetOrder(ctx, o.OrderUID)
+	ctx := context.Background()
+	for _, id := range []string{"1", "2", "3"} {
+		require.NoError(t, c.SaveOrder(ctx, newTestOrder(id)))
+	}
+	for _, id := range []string{"1", "1", "3"} {
+		_, err := c.GetOrder(ctx, id)
 		require.NoError(t, err)
-		assert.Equal(t, o.OrderUID, got.OrderUID)
 	}
+
+	// 2 is the least frequently used
+	require.NoError(t, c.SaveOrder(ctx, newTestOrder("4")))
+	_, err = c.GetOrder(ctx, "2")
+	assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+	for _, id := range []string{"1", "3", "4"} {
+		_, err := c.GetOrder(ctx, id)
+		assert.NoError(t, err)
+	}
+}
+
+// a scan of one-off orders must not flush the orders that are read all the time
+func TestCache_ScanResistance(t *testing.T) {
+	for _, p := range []string{cache.PolicyLFU, cache.PolicyTinyLFU} {
+		t.Run(p, func(t *testing.T) {
+			c, err := cache.New(config.Cache{TTL: 5 * time.Minute, Limit: 100, Policy: p})
+			require.NoError(t, err)
+			defer c.Stop()
+
+			ctx := context.Background()
+			hot := make([]string, 10)
+			for i := range hot {
+				hot[i] = fmt.Sprintf("hot-%d", i)
+				require.NoError(t, c.SaveOrder(ctx, newTestOrder(hot[i])))
+			}
+			for range 10 {
+				for _, id := range hot {
+					_, err := c.GetOrder(ctx, id)
+					require.NoError(t, err)
+				}
+			}
+
+			for i := range 1000 {
+				require.NoError(t, c.SaveOrder(ctx, newTestOrder(fmt.Sprintf("scan-%d", i))))
+			}
+
+			for _, id := range hot {
+				_, err := c.GetOrder(ctx, id)
+				assert.NoError(t, err, id)
+			}
+		})
+	}
+}
+
+func TestSharded_Eviction(t *testing.T) {
+	// a single shard small enough to compare every order is exact LRU
+	c := cache.NewSharded(5*time.Minute, 3, 1)
+	defer c.Stop()
+
+	ctx := context.Background()
+	for _, id := range []string{"1", "2", "3"} {
+		require.NoError(t, c.SaveOrder(ctx, newTestOrder(id)))
+	}
+	_, err := c.GetOrder(ctx, "1")
+	require.NoError(t, err)
+
+	require.NoError(t, c.SaveOrder(ctx, newTestOrder("4")))
+	_, err = c.GetOrder(ctx, "2")
+	assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+	for _, id := range []string{"1", "3", "4"} {
+		_, err := c.GetOrder(ctx, id)
+		assert.NoError(t, err)
+	}
+}
+
+func TestSharded_StaysWithinLimit(t *testing.T) {
+	c := cache.NewSharded(5*time.Minute, 100, 8)
+	defer c.Stop()
+
+	ctx := context.Background()
+	for i := range 1000 {
+		require.NoError(t, c.SaveOrder(ctx, newTestOrder(fmt.Sprintf("o-%d", i))))
+	}
+	assert.LessOrEqual(t, c.Len(), 104) // each shard holds up to ceil(100 / 8)
+}
+
+func TestSharded_SweepsExpired(t *testing.T) {
+	c := cache.NewSharded(10*time.Millisecond, 1000, 4)
+	defer c.Stop()
+
+	ctx := context.Background()
+	for i := range 500 {
+		require.NoError(t, c.SaveOrder(ctx, newTestOrder(fmt.Sprintf("o-%d", i))))
+	}
+	assert.Eventually(t, func() bool { return c.Len() == 0 }, 5*time.Second, 50*time.Millisecond)
 }
@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"cmp"
+	c "context"
+	"l0/internal/metrics"
+	"l0/internal/models"
+	"l0/internal/storage"
+	"slices"
+	"time"
+)
+
+// index maps a secondary key, e.g. a track number, to the cached orders that have it.
+// Several orders may share a key, so a key only answers lookups once it is complete:
+// all of its orders were loaded together and none has been evicted since.
+type index map[string]*indexEntry
+
+type indexEntry struct {
+	uids     map[string]struct{}
+	complete bool
+}
+
+func (ix index) add(key, uid string) {
+	e, ok := ix[key]
+	if !ok {
+		e = &indexEntry{uids: make(map[string]struct{})}
+		ix[key] = e
+	}
+	e.uids[uid] = struct{}{}
+}
+
+// drop unlinks an order that no longer has the key; the key stays complete
+func (ix index) drop(key, uid string) {
+	e, ok := ix[key]
+	if !ok {
+		return
+	}
+	delete(e.uids, uid)
+	if len(e.uids) == 0 {
+		delete(ix, key)
+	}
+}
+
+// evict unlinks an order that left the cache, other orders with the key may still be stored
+func (ix index) evict(key, uid string) {
+	ix.drop(key, uid)
+	ix.invalidate(key)
+}
+
+// invalidate stops a key answering lookups until all of its orders are loaded again
+func (ix index) invalidate(key string) {
+	if e, ok := ix[key]; ok {
+		e.complete = false
+	}
+}
+
+func (ix index) markComplete(key string, uids []string) {
+	e, ok := ix[key]
+	if !ok {
+		return
+	}
+	for _, uid := range uids {
+		if _, ok := e.uids[uid]; !ok {
+			return // evicted straight away, the cache is too small to hold them all
+		}
+	}
+	e.complete = true
+}
+
+// indexes are the secondary keys orders can be looked up by
+type indexes struct {
+	byTrack index
+	byTx    index
+}
+
+func newIndexes() indexes {
+	return indexes{byTrack: make(index), byTx: make(index)}
+}
+
+func (ix indexes) link(o *models.Order) {
+	ix.byTrack.add(o.TrackNumber, o.OrderUID)
+	ix.byTx.add(o.Payment.Transaction, o.OrderUID)
+}
+
+func (ix indexes) relink(old, o *models.Order) {
+	if old.TrackNumber != o.TrackNumber {
+		ix.byTrack.drop(old.TrackNumber, o.OrderUID)
+		ix.byTrack.add(o.TrackNumber, o.OrderUID)
+	}
+	if old.Payment.Transaction != o.Payment.Transaction {
+		ix.byTx.drop(old.Payment.Transaction, o.OrderUID)
+		ix.byTx.add(o.Payment.Transaction, o.OrderUID)
+	}
+}
+
+func (ix indexes) unlink(o *models.Order) {
+	ix.byTrack.evict(o.TrackNumber, o.OrderUID)
+	ix.byTx.evict(o.Payment.Transaction, o.OrderUID)
+}
+
+// invalidate marks the keys an order saved elsewhere may have joined
+func (ix indexes) invalidate(inv storage.Invalidation) {
+	ix.byTrack.invalidate(inv.TrackNumber)
+	ix.byTx.invalidate(inv.Transaction)
+}
+
+// newestFirst orders lookup results like storage does
+func newestFirst(a, b *models.Order) int {
+	return cmp.Or(cmp.Compare(b.DateCreated, a.DateCreated), cmp.Compare(b.OrderUID, a.OrderUID))
+}
+
+// OrdersByTrack gets all orders with a track number, if the cache knows them all
+func (c *Cache) OrdersByTrack(ctx c.Context, track string) ([]*models.Order, error) {
+	return c.lookup(c.idx.byTrack, track)
+}
+
+// OrdersByTransaction gets all orders paid by a transaction, if the cache knows them all
+func (c *Cache) OrdersByTransaction(ctx c.Context, tx string) ([]*models.Order, error) {
+	return c.lookup(c.idx.byTx, tx)
+}
+
+// LoadByTrack caches every order with a track number, which makes OrdersByTrack serve it
+func (c *Cache) LoadByTrack(ctx c.Context, track string, orders []*models.Order) error {
+	return c.loadKey(c.idx.byTrack, track, orders)
+}
+
+// LoadByTransaction caches every order paid by a transaction, which makes OrdersByTransaction serve it
+func (c *Cache) LoadByTransaction(ctx c.Context, tx string, orders []*models.Order) error {
+	return c.loadKey(c.idx.byTx, tx, orders)
+}
+
+func (c *Cache) lookup(ix index, key string) ([]*models.Order, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := ix[key]
+	if !ok || !e.complete {
+		metrics.CacheMisses.Inc()
+		return nil, storage.ErrOrderNotFound
+	}
+	orders := make([]*models.Order, 0, len(e.uids))
+	for uid := range e.uids {
+		entry := c.mp[uid]
+		if time.Since(entry.time) > c.ttl {
+			c.remove(uid) // makes the key incomplete
+			metrics.CacheExpirations.Inc()
+			metrics.CacheMisses.Inc()
+			return nil, storage.ErrOrderNotFound
+		}
+		orders = append(orders, entry.order.Clone())
+	}
+	for _, o := range orders {
+		c.policy.hit(o.OrderUID)
+	}
+	metrics.CacheHits.Inc()
+
+	slices.SortFunc(orders, newestFirst)
+	return orders, nil
+}
+
+func (c *Cache) loadKey(ix index, key string, orders []*models.Order) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	uids := make([]string, 0, len(orders))
+	for _, o := range orders {
+		c.save(o, time.Now())
+		uids = append(uids, o.OrderUID)
+	}
+	ix.markComplete(key, uids)
+	return nil
+}
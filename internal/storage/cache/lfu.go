@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"container/list"
+	"maps"
+	"slices"
+)
+
+// lfu evicts the least frequently used order, the least recently used one among equals.
+// Every operation is O(1): orders are bucketed by their access count.
+type lfu struct {
+	limit   int
+	entries map[string]*lfuEntry
+	buckets map[int]*list.List // access count -> orders, most recent at the front
+	min     int                // lowest access count, may point at a removed bucket
+}
+
+type lfuEntry struct {
+	freq int
+	elem *list.Element
+}
+
+func newLFU(limit int) *lfu {
+	return &lfu{limit: limit, entries: make(map[string]*lfuEntry), buckets: make(map[int]*list.List)}
+}
+
+func (p *lfu) push(uid string, freq int) {
+	b, ok := p.buckets[freq]
+	if !ok {
+		b = list.New()
+		p.buckets[freq] = b
+	}
+	p.entries[uid] = &lfuEntry{freq: freq, elem: b.PushFront(uid)}
+}
+
+func (p *lfu) unlink(uid string) *lfuEntry {
+	e, ok := p.entries[uid]
+	if !ok {
+		return nil
+	}
+	b := p.buckets[e.freq]
+	b.Remove(e.elem)
+	if b.Len() == 0 {
+		delete(p.buckets, e.freq)
+	}
+	delete(p.entries, uid)
+	return e
+}
+
+func (p *lfu) victim() string {
+	if _, ok := p.buckets[p.min]; !ok {
+		// the bucket went away with an explicit removal
+		p.min = 0
+		for freq := range p.buckets {
+			if p.min == 0 || freq < p.min {
+				p.min = freq
+			}
+		}
+	}
+	return p.buckets[p.min].Back().Value.(string)
+}
+
+func (p *lfu) add(uid string) []string {
+	var evicted []string
+	// make room first, the newcomer would always be the least frequently used
+	if len(p.entries) >= p.limit && len(p.entries) > 0 {
+		victim := p.victim()
+		p.unlink(victim)
+		evicted = append(evicted, victim)
+	}
+	if p.limit <= 0 {
+		return append(evicted, uid)
+	}
+	p.push(uid, 1)
+	p.min = 1
+	return evicted
+}
+
+func (p *lfu) hit(uid string) {
+	e := p.unlink(uid)
+	if e == nil {
+		return
+	}
+	if p.min == e.freq {
+		if _, ok := p.buckets[e.freq]; !ok {
+			p.min++
+		}
+	}
+	p.push(uid, e.freq+1)
+}
+
+func (p *lfu) remove(uid string) {
+	p.unlink(uid)
+}
+
+func (p *lfu) keys() []string {
+	uids := make([]string, 0, len(p.entries))
+	for _, freq := range slices.Sorted(maps.Keys(p.buckets)) {
+		uids = backToFront(p.buckets[freq], uids)
+	}
+	return uids
+}
@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"container/list"
+	c "context"
+	"l0/internal/storage"
+	"sync"
+	"time"
+)
+
+// Negative remembers order UIDs storage didn't have, so repeated lookups of them skip the database.
+// It holds at most limit UIDs, forgetting the oldest first; a zero ttl disables it.
+// Invalidations make it forget orders saved since.
+type Negative struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	limit int
+	mp    map[string]*list.Element
+	ll    *list.List // oldest first
+}
+
+type negativeEntry struct {
+	uid  string
+	time time.Time
+}
+
+// NewNegative creates a negative cache
+func NewNegative(ttl time.Duration, limit int) *Negative {
+	return &Negative{
+		ttl:   ttl,
+		limit: limit,
+		mp:    make(map[string]*list.Element),
+		ll:    list.New(),
+	}
+}
+
+// Missing tells whether storage recently didn't have the order
+func (n *Negative) Missing(uid string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	el, ok := n.mp[uid]
+	if !ok {
+		return false
+	}
+	if time.Since(el.Value.(*negativeEntry).time) > n.ttl {
+		n.remove(el)
+		return false
+	}
+	return true
+}
+
+// MarkMissing remembers that storage doesn't have the order
+func (n *Negative) MarkMissing(uid string) {
+	if n.ttl <= 0 || n.limit <= 0 {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if el, ok := n.mp[uid]; ok {
+		el.Value.(*negativeEntry).time = time.Now()
+		n.ll.MoveToBack(el)
+		return
+	}
+	n.mp[uid] = n.ll.PushBack(&negativeEntry{uid: uid, time: time.Now()})
+	if n.ll.Len() > n.limit {
+		n.remove(n.ll.Front())
+	}
+}
+
+// Invalidate forgets an order that has been saved
+func (n *Negative) Invalidate(ctx c.Context, inv storage.Invalidation) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if inv.All {
+		clear(n.mp)
+		n.ll.Init()
+		return nil
+	}
+	if el, ok := n.mp[inv.OrderUID]; ok {
+		n.remove(el)
+	}
+	return nil
+}
+
+func (n *Negative) remove(el *list.Element) {
+	delete(n.mp, el.Value.(*negativeEntry).uid)
+	n.ll.Remove(el)
+}
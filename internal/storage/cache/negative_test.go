@@ -0,0 +1,59 @@
+package cache_test
+
+import (
+	"context"
+	"fmt"
+	"l0/internal/storage"
+	"l0/internal/storage/cache"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var _ interface {
+	Missing(string) bool
+	MarkMissing(string)
+} = (*cache.Negative)(nil)
+
+func TestNegative_Expires(t *testing.T) {
+	n := cache.NewNegative(50*time.Millisecond, 10)
+	assert.False(t, n.Missing("a"))
+
+	n.MarkMissing("a")
+	assert.True(t, n.Missing("a"))
+
+	time.Sleep(60 * time.Millisecond)
+	assert.False(t, n.Missing("a"))
+}
+
+func TestNegative_Limit(t *testing.T) {
+	n := cache.NewNegative(time.Minute, 3)
+	for i := range 4 {
+		n.MarkMissing(fmt.Sprint(i))
+	}
+	assert.False(t, n.Missing("0")) // the oldest goes first
+	for i := 1; i < 4; i++ {
+		assert.True(t, n.Missing(fmt.Sprint(i)))
+	}
+}
+
+func TestNegative_Disabled(t *testing.T) {
+	n := cache.NewNegative(0, 10)
+	n.MarkMissing("a")
+	assert.False(t, n.Missing("a"))
+}
+
+func TestNegative_Invalidate(t *testing.T) {
+	ctx := context.Background()
+	n := cache.NewNegative(time.Minute, 10)
+	n.MarkMissing("a")
+	n.MarkMissing("b")
+
+	assert.NoError(t, n.Invalidate(ctx, storage.Invalidation{OrderUID: "a", Version: 1}))
+	assert.False(t, n.Missing("a")) // saved since
+	assert.True(t, n.Missing("b"))
+
+	assert.NoError(t, n.Invalidate(ctx, storage.Invalidation{All: true}))
+	assert.False(t, n.Missing("b"))
+}
@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+)
+
+// Eviction policies selectable with config.Cache.Policy
+const (
+	PolicyLRU     = "lru"
+	PolicyLFU     = "lfu"
+	PolicyTinyLFU = "tinylfu"
+)
+
+// policy decides which orders stay once the cache is full. Cache serializes all calls.
+type policy interface {
+	// add starts tracking an order and returns the orders to evict, which may include the new one
+	add(uid string) []string
+	// hit records a read or an update of a tracked order
+	hit(uid string)
+	// remove stops tracking an order, it is a no-op for unknown ones
+	remove(uid string)
+	// keys lists the tracked orders, roughly the first to be evicted first
+	keys() []string
+}
+
+func newPolicy(name string, limit int) (policy, error) {
+	switch name {
+	case PolicyLRU, "":
+		return newLRU(limit), nil
+	case PolicyLFU:
+		return newLFU(limit), nil
+	case PolicyTinyLFU:
+		return newTinyLFU(limit), nil
+	}
+	return nil, fmt.Errorf("unknown cache policy %q", name)
+}
+
+// lru evicts the least recently used order
+type lru struct {
+	limit int
+	ll    *list.List // front is the most recently used
+	elems map[string]*list.Element
+}
+
+func newLRU(limit int) *lru {
+	return &lru{limit: limit, ll: list.New(), elems: make(map[string]*list.Element)}
+}
+
+func (p *lru) add(uid string) []string {
+	p.elems[uid] = p.ll.PushFront(uid)
+	if p.ll.Len() <= p.limit {
+		return nil
+	}
+	victim := p.ll.Remove(p.ll.Back()).(string)
+	delete(p.elems, victim)
+	return []string{victim}
+}
+
+func (p *lru) hit(uid string) {
+	if e, ok := p.elems[uid]; ok {
+		p.ll.MoveToFront(e)
+	}
+}
+
+func (p *lru) remove(uid string) {
+	if e, ok := p.elems[uid]; ok {
+		p.ll.Remove(e)
+		delete(p.elems, uid)
+	}
+}
+
+func (p *lru) keys() []string {
+	return backToFront(p.ll, make([]string, 0, p.ll.Len()))
+}
+
+// backToFront appends the orders of a list, least recent first
+func backToFront(ll *list.List, uids []string) []string {
+	for e := ll.Back(); e != nil; e = e.Prev() {
+		uids = append(uids, e.Value.(string))
+	}
+	return uids
+}
@@ -0,0 +1,332 @@
+package cache
+
+import (
+	c "context"
+	"hash/maphash"
+	"l0/internal/metrics"
+	"l0/internal/models"
+	"l0/internal/storage"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	evictionSamples = 5 // orders compared to pick an eviction victim
+	sweepInterval   = 100 * time.Millisecond
+	sweepBatch      = 64 // orders checked for expiry per shard and sweep
+)
+
+// Sharded is a cache split into independently locked shards, picked by a hash of the order UID.
+// Reads only take their shard's read lock: recency is an atomic timestamp, and eviction
+// approximates LRU by dropping the least recently used of a few sampled orders.
+// Expired orders are swept a few at a time, never holding a lock for long.
+type Sharded struct {
+	shards   []*shard
+	seed     maphash.Seed
+	ttl      time.Duration
+	size     atomic.Int64
+	stopChan chan struct{}
+
+	idxMu sync.Mutex // taken after a shard lock, never before
+	idx   indexes
+}
+
+type shard struct {
+	mu      sync.RWMutex
+	entries map[string]*shardEntry
+	keys    []string // sampled for eviction, swept for expiry
+	cursor  int      // next key to sweep
+	limit   int
+}
+
+// shardEntry is replaced rather than modified, readers may still hold the old one.
+// Its order is a copy of the saved one and is copied again for every read.
+type shardEntry struct {
+	order models.Order
+	saved time.Time
+	used  atomic.Int64 // last access, unix nanoseconds
+	pos   int          // in shard.keys
+}
+
+// NewSharded creates a cache of n shards sharing limit between them
+func NewSharded(ttl time.Duration, limit, n int) *Sharded {
+	n = max(n, 1)
+	s := &Sharded{
+		shards:   make([]*shard, n),
+		seed:     maphash.MakeSeed(),
+		ttl:      ttl,
+		stopChan: make(chan struct{}),
+		idx:      newIndexes(),
+	}
+	for i := range s.shards {
+		s.shards[i] = &shard{entries: make(map[string]*shardEntry), limit: (limit + n - 1) / n}
+	}
+
+	go s.sweep()
+	return s
+}
+
+func (s *Sharded) shard(uid string) *shard {
+	return s.shards[maphash.String(s.seed, uid)%uint64(len(s.shards))]
+}
+
+// SaveOrder saves
+func (s *Sharded) SaveOrder(ctx c.Context, order *models.Order) error {
+	s.save(order, time.Now())
+	return nil
+}
+
+// save caches an order saved at a given time, which is when its TTL starts
+func (s *Sharded) save(order *models.Order, at time.Time) {
+	sh := s.shard(order.OrderUID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	e := &shardEntry{order: *order.Clone(), saved: at}
+	e.used.Store(time.Now().UnixNano())
+
+	if old, ok := sh.entries[order.OrderUID]; ok {
+		if old.order.Version > order.Version {
+			return // never replace a newer version
+		}
+		e.pos = old.pos
+		sh.entries[order.OrderUID] = e
+		s.idxMu.Lock()
+		s.idx.relink(&old.order, order)
+		s.idxMu.Unlock()
+		return
+	}
+
+	e.pos = len(sh.keys)
+	sh.keys = append(sh.keys, order.OrderUID)
+	sh.entries[order.OrderUID] = e
+	s.idxMu.Lock()
+	s.idx.link(order)
+	s.idxMu.Unlock()
+	s.size.Add(1)
+
+	if len(sh.entries) > sh.limit {
+		s.remove(sh, sh.victim())
+		metrics.CacheEvictions.Inc()
+	}
+	metrics.CacheSize.Set(float64(s.size.Load()))
+}
+
+// victim picks the least recently used of a few random orders, or of all of them in a small shard
+func (sh *shard) victim() string {
+	var (
+		victim string
+		oldest int64
+	)
+	pick := func(uid string) {
+		if used := sh.entries[uid].used.Load(); victim == "" || used < oldest {
+			victim, oldest = uid, used
+		}
+	}
+	if len(sh.keys) <= evictionSamples {
+		for _, uid := range sh.keys {
+			pick(uid)
+		}
+		return victim
+	}
+	for range evictionSamples {
+		pick(sh.keys[rand.IntN(len(sh.keys))])
+	}
+	return victim
+}
+
+func (s *Sharded) remove(sh *shard, uid string) {
+	e, ok := sh.entries[uid]
+	if !ok {
+		return
+	}
+	last := len(sh.keys) - 1
+	moved := sh.keys[last]
+	sh.keys[e.pos] = moved
+	sh.entries[moved].pos = e.pos
+	sh.keys = sh.keys[:last]
+	delete(sh.entries, uid)
+
+	s.idxMu.Lock()
+	s.idx.unlink(&e.order)
+	s.idxMu.Unlock()
+	metrics.CacheSize.Set(float64(s.size.Add(-1)))
+}
+
+func (s *Sharded) get(uid string) (*models.Order, bool) {
+	sh := s.shard(uid)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	e, ok := sh.entries[uid]
+	if !ok || time.Since(e.saved) > s.ttl { // expired orders are left to the sweep
+		return nil, false
+	}
+	e.used.Store(time.Now().UnixNano())
+	return e.order.Clone(), true
+}
+
+// GetOrder gets an order from the cache
+func (s *Sharded) GetOrder(ctx c.Context, orderID string) (*models.Order, error) {
+	order, ok := s.get(orderID)
+	if !ok {
+		metrics.CacheMisses.Inc()
+		return nil, storage.ErrOrderNotFound
+	}
+	metrics.CacheHits.Inc()
+	return order, nil
+}
+
+// LoadOrders loads orders provided
+func (s *Sharded) LoadOrders(ctx c.Context, orders []*models.Order) error {
+	for _, order := range orders {
+		if err := s.SaveOrder(ctx, order); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OrdersByTrack gets all orders with a track number, if the cache knows them all
+func (s *Sharded) OrdersByTrack(ctx c.Context, track string) ([]*models.Order, error) {
+	return s.lookup(s.idx.byTrack, track)
+}
+
+// OrdersByTransaction gets all orders paid by a transaction, if the cache knows them all
+func (s *Sharded) OrdersByTransaction(ctx c.Context, tx string) ([]*models.Order, error) {
+	return s.lookup(s.idx.byTx, tx)
+}
+
+// LoadByTrack caches every order with a track number, which makes OrdersByTrack serve it
+func (s *Sharded) LoadByTrack(ctx c.Context, track string, orders []*models.Order) error {
+	return s.loadKey(ctx, s.idx.byTrack, track, orders)
+}
+
+// LoadByTransaction caches every order paid by a transaction, which makes OrdersByTransaction serve it
+func (s *Sharded) LoadByTransaction(ctx c.Context, tx string, orders []*models.Order) error {
+	return s.loadKey(ctx, s.idx.byTx, tx, orders)
+}
+
+func (s *Sharded) lookup(ix index, key string) ([]*models.Order, error) {
+	s.idxMu.Lock()
+	e, ok := ix[key]
+	if !ok || !e.complete {
+		s.idxMu.Unlock()
+		metrics.CacheMisses.Inc()
+		return nil, storage.ErrOrderNotFound
+	}
+	uids := slices.Collect(maps.Keys(e.uids))
+	s.idxMu.Unlock()
+
+	orders := make([]*models.Order, 0, len(uids))
+	for _, uid := range uids {
+		order, ok := s.get(uid)
+		if !ok {
+			metrics.CacheMisses.Inc()
+			return nil, storage.ErrOrderNotFound
+		}
+		orders = append(orders, order)
+	}
+	metrics.CacheHits.Inc()
+	slices.SortFunc(orders, newestFirst)
+	return orders, nil
+}
+
+func (s *Sharded) loadKey(ctx c.Context, ix index, key string, orders []*models.Order) error {
+	if err := s.LoadOrders(ctx, orders); err != nil {
+		return err
+	}
+	uids := make([]string, 0, len(orders))
+	for _, o := range orders {
+		uids = append(uids, o.OrderUID)
+	}
+	s.idxMu.Lock()
+	ix.markComplete(key, uids)
+	s.idxMu.Unlock()
+	return nil
+}
+
+// Invalidate drops a cached order older than the one saved, wherever it was saved
+func (s *Sharded) Invalidate(ctx c.Context, inv storage.Invalidation) error {
+	if inv.All {
+		for _, sh := range s.shards {
+			sh.mu.Lock()
+			for len(sh.keys) > 0 {
+				s.remove(sh, sh.keys[len(sh.keys)-1])
+				metrics.CacheInvalidations.Inc()
+			}
+			sh.cursor = 0
+			sh.mu.Unlock()
+		}
+		return nil
+	}
+
+	sh := s.shard(inv.OrderUID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if e, ok := sh.entries[inv.OrderUID]; ok {
+		if !inv.Supersedes(e.order.Version) {
+			return nil // saved here, or already caught up
+		}
+		s.remove(sh, inv.OrderUID)
+		metrics.CacheInvalidations.Inc()
+	}
+	s.idxMu.Lock()
+	s.idx.invalidate(inv)
+	s.idxMu.Unlock()
+	return nil
+}
+
+// Len returns the number of cached orders, expired ones included until they are swept
+func (s *Sharded) Len() int {
+	return int(s.size.Load())
+}
+
+func (s *Sharded) sweep() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, sh := range s.shards {
+				s.sweepShard(sh)
+			}
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// sweepShard drops expired orders among the next sweepBatch of a shard
+func (s *Sharded) sweepShard(sh *shard) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	now := time.Now()
+	for range min(sweepBatch, len(sh.keys)) {
+		if len(sh.keys) == 0 {
+			return
+		}
+		if sh.cursor >= len(sh.keys) {
+			sh.cursor = 0
+		}
+		uid := sh.keys[sh.cursor]
+		if now.Sub(sh.entries[uid].saved) > s.ttl {
+			s.remove(sh, uid) // the last key moves into the cursor's slot, it is checked next
+			metrics.CacheExpirations.Inc()
+			continue
+		}
+		sh.cursor++
+	}
+}
+
+// Stop stops.
+func (s *Sharded) Stop() {
+	close(s.stopChan)
+}
@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"cmp"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"l0/internal/models"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// snapshotFormat changes along with the snapshot layout, snapshots of another format are not restored
+const snapshotFormat = 1
+
+// ErrBadSnapshot is returned for a snapshot file that is corrupt or of another format
+var ErrBadSnapshot = errors.New("bad cache snapshot")
+
+type snapshot struct {
+	Format  int             `json:"format"`
+	TakenAt time.Time       `json:"taken_at"`
+	Entries []snapshotEntry `json:"entries"` // roughly the first to be evicted first
+}
+
+type snapshotEntry struct {
+	Order   models.Order `json:"order"`
+	SavedAt time.Time    `json:"saved_at"` // the TTL keeps counting from here after a restore
+}
+
+// SnapshotFile keeps a snapshot of a cache in a gzipped JSON file, so a restarted service starts warm
+type SnapshotFile struct {
+	path  string
+	cache Interface
+}
+
+// NewSnapshotFile creates a snapshot file for a cache, nothing is read or written yet
+func NewSnapshotFile(path string, cache Interface) *SnapshotFile {
+	return &SnapshotFile{path: path, cache: cache}
+}
+
+// Save writes a snapshot of the cache and returns how many orders it holds.
+// The previous snapshot is only replaced once the new one is complete.
+func (f *SnapshotFile) Save() (int, error) {
+	const op = "cache.SnapshotFile.Save"
+	s := f.cache.snapshot()
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }() // fails once renamed
+	defer func() { _ = tmp.Close() }()
+
+	zw := gzip.NewWriter(tmp)
+	if err = json.NewEncoder(zw).Encode(s); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	if err = zw.Close(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	if err = tmp.Sync(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	if err = tmp.Close(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	if err = os.Rename(tmp.Name(), f.path); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return len(s.Entries), nil
+}
+
+// Restore fills the cache from the snapshot, skipping expired orders. It returns when the
+// snapshot was taken and how many orders were restored. A missing file is os.ErrNotExist,
+// a corrupt one ErrBadSnapshot; the cache is left untouched then.
+func (f *SnapshotFile) Restore() (time.Time, int, error) {
+	const op = "cache.SnapshotFile.Restore"
+	file, err := os.Open(f.path)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = file.Close() }()
+
+	s, err := readSnapshot(file)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("%s: %w: %w", op, ErrBadSnapshot, err)
+	}
+	return s.TakenAt, f.cache.restore(s), nil
+}
+
+func readSnapshot(r io.Reader) (snapshot, error) {
+	var s snapshot
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return s, err
+	}
+	if err = json.NewDecoder(zr).Decode(&s); err != nil {
+		return s, err
+	}
+	// the checksum is only verified at the end of the stream
+	if _, err = io.Copy(io.Discard, zr); err != nil {
+		return s, err
+	}
+	if s.Format != snapshotFormat {
+		return s, fmt.Errorf("format %d, want %d", s.Format, snapshotFormat)
+	}
+	return s, nil
+}
+
+func (c *Cache) snapshot() snapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := c.policy.keys()
+	s := snapshot{Format: snapshotFormat, TakenAt: time.Now(), Entries: make([]snapshotEntry, 0, len(keys))}
+	for _, uid := range keys {
+		// entries are replaced, never modified, so the copy can be encoded without the lock
+		e := c.mp[uid]
+		s.Entries = append(s.Entries, snapshotEntry{Order: e.order, SavedAt: e.time})
+	}
+	return s
+}
+
+func (c *Cache) restore(s snapshot) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for i := range s.Entries {
+		e := &s.Entries[i]
+		if time.Since(e.SavedAt) > c.ttl {
+			continue
+		}
+		c.save(&e.Order, e.SavedAt)
+		n++
+	}
+	return n
+}
+
+func (s *Sharded) snapshot() snapshot {
+	type used struct {
+		e    snapshotEntry
+		used int64
+	}
+	var all []used
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for _, e := range sh.entries {
+			all = append(all, used{snapshotEntry{Order: e.order, SavedAt: e.saved}, e.used.Load()})
+		}
+		sh.mu.RUnlock()
+	}
+	slices.SortFunc(all, func(a, b used) int { return cmp.Compare(a.used, b.used) })
+
+	snap := snapshot{Format: snapshotFormat, TakenAt: time.Now(), Entries: make([]snapshotEntry, 0, len(all))}
+	for _, u := range all {
+		snap.Entries = append(snap.Entries, u.e)
+	}
+	return snap
+}
+
+func (s *Sharded) restore(snap snapshot) int {
+	n := 0
+	for i := range snap.Entries {
+		e := &snap.Entries[i]
+		if time.Since(e.SavedAt) > s.ttl {
+			continue
+		}
+		s.save(&e.Order, e.SavedAt) // in order, so recency comes back too
+		n++
+	}
+	return n
+}
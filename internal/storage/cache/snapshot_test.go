@@ -0,0 +1,135 @@
+package cache_test
+
+import (
+	"context"
+	"l0/internal/config"
+	"l0/internal/storage"
+	"l0/internal/storage/cache"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotFile_RoundTrip(t *testing.T) {
+	forEachPolicy(t, 5*time.Minute, 10, func(t *testing.T, c cache.Interface) {
+		ctx := context.Background()
+		for _, id := range []string{"a", "b", "c"} {
+			require.NoError(t, c.SaveOrder(ctx, withItem(newTestOrder(id))))
+		}
+		path := filepath.Join(t.TempDir(), "cache.snapshot")
+		saved, err := cache.NewSnapshotFile(path, c).Save()
+		require.NoError(t, err)
+		assert.Equal(t, 3, saved)
+
+		restored, err := cache.New(config.Cache{TTL: 5 * time.Minute, Limit: 10})
+		require.NoError(t, err)
+		defer restored.Stop()
+		takenAt, n, err := cache.NewSnapshotFile(path, restored).Restore()
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+		assert.WithinDuration(t, time.Now(), takenAt, time.Minute)
+		for _, id := range []string{"a", "b", "c"} {
+			got, err := restored.GetOrder(ctx, id)
+			require.NoError(t, err)
+			assert.Equal(t, withItem(newTestOrder(id)), got)
+		}
+	})
+}
+
+func TestSnapshotFile_KeepsRecency(t *testing.T) {
+	for name, cfg := range map[string]config.Cache{"lru": {}, "sharded": {Shards: 1}} {
+		t.Run(name, func(t *testing.T) {
+			cfg.TTL, cfg.Limit = 5*time.Minute, 3
+			ctx := context.Background()
+			c, err := cache.New(cfg)
+			require.NoError(t, err)
+			defer c.Stop()
+			for _, id := range []string{"a", "b", "c"} {
+				require.NoError(t, c.SaveOrder(ctx, newTestOrder(id)))
+				time.Sleep(time.Millisecond)
+			}
+			_, err = c.GetOrder(ctx, "a") // b is the least recently used now
+			require.NoError(t, err)
+
+			path := filepath.Join(t.TempDir(), "cache.snapshot")
+			_, err = cache.NewSnapshotFile(path, c).Save()
+			require.NoError(t, err)
+
+			restored, err := cache.New(cfg)
+			require.NoError(t, err)
+			defer restored.Stop()
+			_, _, err = cache.NewSnapshotFile(path, restored).Restore()
+			require.NoError(t, err)
+
+			require.NoError(t, restored.SaveOrder(ctx, newTestOrder("d")))
+			_, err = restored.GetOrder(ctx, "b")
+			assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+			for _, id := range []string{"a", "c", "d"} {
+				_, err = restored.GetOrder(ctx, id)
+				assert.NoError(t, err, id)
+			}
+		})
+	}
+}
+
+func TestSnapshotFile_KeepsSaveTime(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewCache(200*time.Millisecond, 10)
+	defer c.Stop()
+	require.NoError(t, c.SaveOrder(ctx, newTestOrder("old")))
+	time.Sleep(120 * time.Millisecond)
+	require.NoError(t, c.SaveOrder(ctx, newTestOrder("new")))
+
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	_, err := cache.NewSnapshotFile(path, c).Save()
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond) // old has expired by now
+
+	restored := cache.NewCache(200*time.Millisecond, 10)
+	defer restored.Stop()
+	_, n, err := cache.NewSnapshotFile(path, restored).Restore()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	_, err = restored.GetOrder(ctx, "new")
+	assert.NoError(t, err)
+
+	time.Sleep(120 * time.Millisecond) // the TTL runs from the original save
+	_, err = restored.GetOrder(ctx, "new")
+	assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+}
+
+func TestSnapshotFile_Unusable(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	c := cache.NewCache(5*time.Minute, 10)
+	defer c.Stop()
+
+	_, _, err := cache.NewSnapshotFile(filepath.Join(dir, "missing"), c).Restore()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	corrupt := filepath.Join(dir, "corrupt")
+	require.NoError(t, os.WriteFile(corrupt, []byte("not gzip"), 0o600))
+	_, _, err = cache.NewSnapshotFile(corrupt, c).Restore()
+	assert.ErrorIs(t, err, cache.ErrBadSnapshot)
+
+	// a snapshot cut short, e.g. by a full disk
+	src := cache.NewCache(5*time.Minute, 10)
+	defer src.Stop()
+	require.NoError(t, src.SaveOrder(ctx, withItem(newTestOrder("a"))))
+	full := filepath.Join(dir, "full")
+	_, err = cache.NewSnapshotFile(full, src).Save()
+	require.NoError(t, err)
+	data, err := os.ReadFile(full)
+	require.NoError(t, err)
+	truncated := filepath.Join(dir, "truncated")
+	require.NoError(t, os.WriteFile(truncated, data[:len(data)-4], 0o600))
+	_, _, err = cache.NewSnapshotFile(truncated, c).Restore()
+	assert.ErrorIs(t, err, cache.ErrBadSnapshot)
+
+	_, err = c.GetOrder(ctx, "a")
+	assert.ErrorIs(t, err, storage.ErrOrderNotFound) // left untouched
+}
@@ -0,0 +1,89 @@
+package cache
+
+import (
+	c "context"
+	"errors"
+	"l0/internal/metrics"
+	"l0/internal/models"
+	"l0/internal/storage"
+)
+
+// Remote is a second-level cache shared by replicas, e.g. Redis. SaveOrder replaces a cached order
+// with one just saved, LoadOrders fills in orders read from the database and keeps cached ones.
+type Remote interface {
+	GetOrder(c.Context, string) (*models.Order, error)
+	SaveOrder(c.Context, *models.Order) error
+	LoadOrders(c.Context, []*models.Order) error
+	Invalidate(c.Context, storage.Invalidation) error
+	Close() error
+}
+
+// Tiered puts a remote second-level cache behind an in-memory one. Reads fall through
+// to the second level and fill the first, writes go to both. The second level is best effort:
+// its failures are counted and taken for misses. Lookups by track number and transaction,
+// snapshots and everything else only concern the first level.
+type Tiered struct {
+	Interface
+	l2 Remote
+}
+
+// NewTiered creates a two-level cache
+func NewTiered(l1 Interface, l2 Remote) *Tiered {
+	return &Tiered{Interface: l1, l2: l2}
+}
+
+// GetOrder gets an order from the first level, then the second
+func (t *Tiered) GetOrder(ctx c.Context, orderID string) (*models.Order, error) {
+	if order, err := t.Interface.GetOrder(ctx, orderID); err == nil {
+		return order, nil
+	}
+	order, err := t.l2.GetOrder(ctx, orderID)
+	if err != nil {
+		if errors.Is(err, storage.ErrOrderNotFound) {
+			metrics.CacheL2Misses.Inc()
+		} else {
+			metrics.CacheL2Errors.Inc()
+		}
+		return nil, storage.ErrOrderNotFound
+	}
+	metrics.CacheL2Hits.Inc()
+	_ = t.Interface.SaveOrder(ctx, order) // nil always
+	return order, nil
+}
+
+// SaveOrder saves an order in both levels
+func (t *Tiered) SaveOrder(ctx c.Context, order *models.Order) error {
+	if err := t.Interface.SaveOrder(ctx, order); err != nil {
+		return err
+	}
+	if err := t.l2.SaveOrder(ctx, order); err != nil {
+		metrics.CacheL2Errors.Inc()
+	}
+	return nil
+}
+
+// LoadOrders loads orders read from the database into both levels
+func (t *Tiered) LoadOrders(ctx c.Context, orders []*models.Order) error {
+	if err := t.Interface.LoadOrders(ctx, orders); err != nil {
+		return err
+	}
+	if err := t.l2.LoadOrders(ctx, orders); err != nil {
+		metrics.CacheL2Errors.Inc()
+	}
+	return nil
+}
+
+// Invalidate drops an order older than the one saved from both levels. The second level goes first:
+// a read in between would otherwise miss the first, find the old order in the second and put it back.
+func (t *Tiered) Invalidate(ctx c.Context, inv storage.Invalidation) error {
+	if err := t.l2.Invalidate(ctx, inv); err != nil {
+		metrics.CacheL2Errors.Inc()
+	}
+	return t.Interface.Invalidate(ctx, inv)
+}
+
+// Stop stops the first level and disconnects from the second
+func (t *Tiered) Stop() {
+	t.Interface.Stop()
+	_ = t.l2.Close()
+}
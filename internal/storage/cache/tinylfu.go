@@ -0,0 +1,232 @@
+package cache
+
+import (
+	"container/list"
+	"hash/maphash"
+)
+
+// tinyLFU is W-TinyLFU: new orders go through a small LRU window, then have to beat
+// the main area's eviction candidate on estimated access frequency to stay. One-off
+// reads such as scans or a warm-up never push out orders that are read often.
+// The main area is a segmented LRU: orders read again while on probation become protected.
+type tinyLFU struct {
+	sketch *sketch
+
+	window, probation, protected *list.List
+	windowCap, mainCap, protCap  int
+
+	entries map[string]*tinyEntry
+}
+
+type segment uint8
+
+const (
+	inWindow segment = iota
+	onProbation
+	inProtected
+)
+
+type tinyEntry struct {
+	seg  segment
+	elem *list.Element
+}
+
+func newTinyLFU(limit int) *tinyLFU {
+	windowCap := max(1, limit/100)
+	mainCap := max(0, limit-windowCap)
+	return &tinyLFU{
+		sketch:    newSketch(limit),
+		window:    list.New(),
+		probation: list.New(),
+		protected: list.New(),
+		windowCap: windowCap,
+		mainCap:   mainCap,
+		protCap:   mainCap * 4 / 5,
+		entries:   make(map[string]*tinyEntry),
+	}
+}
+
+func (p *tinyLFU) segment(seg segment) *list.List {
+	switch seg {
+	case inWindow:
+		return p.window
+	case onProbation:
+		return p.probation
+	}
+	return p.protected
+}
+
+func (p *tinyLFU) push(uid string, seg segment) {
+	p.entries[uid] = &tinyEntry{seg: seg, elem: p.segment(seg).PushFront(uid)}
+}
+
+func (p *tinyLFU) unlink(uid string) {
+	if e, ok := p.entries[uid]; ok {
+		p.segment(e.seg).Remove(e.elem)
+		delete(p.entries, uid)
+	}
+}
+
+func (p *tinyLFU) add(uid string) []string {
+	p.sketch.increment(uid)
+	if p.windowCap+p.mainCap <= 0 {
+		return []string{uid}
+	}
+	p.push(uid, inWindow)
+	if p.window.Len() <= p.windowCap {
+		return nil
+	}
+
+	candidate := p.window.Back().Value.(string)
+	p.unlink(candidate)
+	if p.probation.Len()+p.protected.Len() < p.mainCap {
+		p.push(candidate, onProbation)
+		return nil
+	}
+
+	victims := p.probation
+	if victims.Len() == 0 {
+		victims = p.protected
+	}
+	if victims.Len() == 0 {
+		return []string{candidate}
+	}
+	victim := victims.Back().Value.(string)
+	if p.sketch.estimate(candidate) <= p.sketch.estimate(victim) {
+		return []string{candidate}
+	}
+	p.unlink(victim)
+	p.push(candidate, onProbation)
+	return []string{victim}
+}
+
+func (p *tinyLFU) hit(uid string) {
+	p.sketch.increment(uid)
+	e, ok := p.entries[uid]
+	if !ok {
+		return
+	}
+	switch e.seg {
+	case inWindow, inProtected:
+		p.segment(e.seg).MoveToFront(e.elem)
+	case onProbation:
+		p.unlink(uid)
+		p.push(uid, inProtected)
+		if p.protected.Len() > p.protCap {
+			demoted := p.protected.Back().Value.(string)
+			p.unlink(demoted)
+			p.push(demoted, onProbation)
+		}
+	}
+}
+
+func (p *tinyLFU) remove(uid string) {
+	p.unlink(uid)
+}
+
+// keys lists the main area before the window, access frequencies aren't kept
+func (p *tinyLFU) keys() []string {
+	uids := make([]string, 0, len(p.entries))
+	uids = backToFront(p.probation, uids)
+	uids = backToFront(p.protected, uids)
+	return backToFront(p.window, uids)
+}
+
+// sketch is a count-min sketch of small saturating counters. It halves all of them once
+// it has counted ten times as many accesses as the cache holds, so old popularity fades.
+// A doorkeeper bloom filter takes the first access of every order, which keeps one-off
+// reads out of the counters and their collisions away from the orders that matter.
+type sketch struct {
+	rows    [4][]uint8
+	mask    uint64
+	door    []uint64
+	doorLen uint64
+	seed    maphash.Seed
+	added   int
+	resetAt int
+}
+
+const sketchMax = 15
+
+func newSketch(limit int) *sketch {
+	s := &sketch{seed: maphash.MakeSeed(), resetAt: 10 * max(limit, 1)}
+	// a few counters per cached order keep collisions rare between resets
+	width := 16
+	for width < 4*limit {
+		width <<= 1
+	}
+	s.mask = uint64(width - 1)
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	// 8 bits per access until the next reset, under 5% false positives
+	doorBits := 64
+	for doorBits < 8*s.resetAt {
+		doorBits <<= 1
+	}
+	s.door, s.doorLen = make([]uint64, doorBits/64), uint64(doorBits)
+	return s
+}
+
+// index derives the i-th hash of an order, mixed so that orders colliding in one row rarely collide in another
+func (s *sketch) index(h uint64, i int) uint64 {
+	x := h + uint64(i+1)*0x9e3779b97f4a7c15 // splitmix64
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// admit sets the doorkeeper bits of h, reporting whether they were all set already
+func (s *sketch) admit(h uint64) bool {
+	seen := true
+	for i := range 3 {
+		bit := s.index(h, i) & (s.doorLen - 1)
+		if s.door[bit/64]&(1<<(bit%64)) == 0 {
+			seen = false
+			s.door[bit/64] |= 1 << (bit % 64)
+		}
+	}
+	return seen
+}
+
+func (s *sketch) admitted(h uint64) bool {
+	for i := range 3 {
+		bit := s.index(h, i) & (s.doorLen - 1)
+		if s.door[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *sketch) increment(uid string) {
+	h := maphash.String(s.seed, uid)
+	if s.admit(h) {
+		for i := range s.rows {
+			if c := &s.rows[i][s.index(h, i)&s.mask]; *c < sketchMax {
+				*c++
+			}
+		}
+	}
+	if s.added++; s.added >= s.resetAt {
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] >>= 1
+			}
+		}
+		clear(s.door)
+		s.added /= 2
+	}
+}
+
+func (s *sketch) estimate(uid string) uint8 {
+	h := maphash.String(s.seed, uid)
+	est := uint8(sketchMax)
+	for i := range s.rows {
+		est = min(est, s.rows[i][s.index(h, i)&s.mask])
+	}
+	if s.admitted(h) {
+		est++
+	}
+	return est
+}
@@ -0,0 +1,184 @@
+package postgres
+
+import (
+	c "context"
+	"iter"
+	"l0/internal/metrics"
+	"l0/internal/models"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// RecentOrders streams up to limit most recent orders in batches of batchSize.
+// Batches go from older to newer, so the newest orders end up most recently used
+// when fed into an LRU cache.
+func (s *Storage) RecentOrders(ctx c.Context, limit, batchSize int) iter.Seq2[[]*models.Order, error] {
+	const op = "storage.postgres.RecentOrders"
+	if batchSize <= 0 {
+		batchSize = limit
+	}
+	return s.inBatches(ctx, op, batchSize, func() ([]string, error) {
+		return s.recentUIDs(ctx, limit)
+	})
+}
+
+// OrdersSavedSince streams up to limit orders with a revision created after since,
+// in batches of batchSize, the most recently saved last
+func (s *Storage) OrdersSavedSince(ctx c.Context, since time.Time, limit, batchSize int) iter.Seq2[[]*models.Order, error] {
+	const op = "storage.postgres.OrdersSavedSince"
+	if batchSize <= 0 {
+		batchSize = limit
+	}
+	return s.inBatches(ctx, op, batchSize, func() ([]string, error) {
+		return s.uidsSavedSince(ctx, since, limit)
+	})
+}
+
+// inBatches loads the orders listed by list, batchSize at a time
+func (s *Storage) inBatches(ctx c.Context, op string, batchSize int, list func() ([]string, error)) iter.Seq2[[]*models.Order, error] {
+	return func(yield func([]*models.Order, error) bool) {
+		uids, err := list()
+		if err != nil {
+			yield(nil, fmterr(op, err))
+			return
+		}
+		for start := 0; start < len(uids); start += batchSize {
+			began := time.Now()
+			orders, err := s.ordersByUIDs(ctx, uids[start:min(start+batchSize, len(uids))])
+			metrics.ObserveQuery(op, began)
+			if err != nil {
+				yield(nil, fmterr(op, err))
+				return
+			}
+			if !yield(orders, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (s *Storage) recentUIDs(ctx c.Context, limit int) ([]string, error) {
+	return s.uids(ctx, limit, `SELECT order_uid FROM (
+			SELECT order_uid, date_created FROM orders ORDER BY date_created DESC, order_uid DESC LIMIT $1
+		) recent ORDER BY date_created, order_uid`, limit)
+}
+
+func (s *Storage) uidsSavedSince(ctx c.Context, since time.Time, limit int) ([]string, error) {
+	return s.uids(ctx, limit, `SELECT order_uid FROM (
+			SELECT order_uid, max(created_at) AS saved_at FROM order_revisions WHERE created_at > $1
+			GROUP BY order_uid ORDER BY saved_at DESC, order_uid DESC LIMIT $2
+		) saved ORDER BY saved_at, order_uid`, since, limit)
+}
+
+func (s *Storage) uids(ctx c.Context, limit int, query string, args ...any) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	uids := make([]string, 0, limit)
+	for rows.Next() {
+		var uid string
+		if err := rows.Scan(&uid); err != nil {
+			return nil, err
+		}
+		uids = append(uids, uid)
+	}
+	return uids, rows.Err()
+}
+
+// ordersByUIDs loads full orders with two set-based queries inside one transaction.
+// The result follows the order of uids; unknown uids are skipped.
+func (s *Storage) ordersByUIDs(ctx c.Context, uids []string) ([]*models.Order, error) {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.QueryContext(ctx, `SELECT o.order_uid, o.track_number, o.entry, o.locale, o.internal_signature,
+			o.customer_id, o.delivery_service, o.shardkey, o.sm_id, o.date_created, o.oof_shard, o.version,
+			p.transaction, p.request_id, p.currency, p.provider, p.amount, p.payment_dt, p.bank,
+			p.delivery_cost, p.goods_total, p.custom_fee,
+			u.name, u.phone, a.zip, a.city, a.address, a.region, u.email
+		FROM orders o
+			JOIN payments p ON p.transaction = o.payment
+			JOIN addresses a ON a.id = o.delivery
+			JOIN users u ON u.customer_id = a.customer_id
+		WHERE o.order_uid = ANY($1)`, pq.Array(uids))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	byUID := make(map[string]*models.Order, len(uids))
+	for rows.Next() {
+		o := &models.Order{}
+		p, d := &o.Payment, &o.Delivery
+		err = rows.Scan(&o.OrderUID, &o.TrackNumber, &o.Entry, &o.Locale, &o.InternalSignature,
+			&o.CustomerID, &o.DeliveryService, &o.ShardKey, &o.SmID, &o.DateCreated, &o.OofShard, &o.Version,
+			&p.Transaction, &p.RequestID, &p.Currency, &p.Provider, &p.Amount, &p.PaymentDT, &p.Bank,
+			&p.DeliveryCost, &p.GoodsTotal, &p.CustomFee,
+			&d.Name, &d.Phone, &d.Zip, &d.City, &d.Address, &d.Region, &d.Email)
+		if err != nil {
+			return nil, err
+		}
+		byUID[o.OrderUID] = o
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	_ = rows.Close()
+
+	items, err := tx.QueryContext(ctx, `SELECT order_uid, chrt_id, track_number, price, rid, name, sale,
+			size, total_price, nm_id, brand, status
+		FROM order_items
+		WHERE order_uid = ANY($1)
+		ORDER BY order_uid, line`, pq.Array(uids))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = items.Close() }()
+
+	for items.Next() {
+		var (
+			uid  string
+			item models.Item
+		)
+		err = items.Scan(&uid, &item.ChrtID, &item.TrackNumber, &item.Price, &item.RID, &item.Name, &item.Sale,
+			&item.Size, &item.TotalPrice, &item.NmID, &item.Brand, &item.Status)
+		if err != nil {
+			return nil, err
+		}
+		if o, ok := byUID[uid]; ok {
+			o.Items = append(o.Items, item)
+		}
+	}
+	if err := items.Err(); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	orders := make([]*models.Order, 0, len(byUID))
+	for _, uid := range uids {
+		if o, ok := byUID[uid]; ok {
+			orders = append(orders, o)
+		}
+	}
+	return orders, nil
+}
+
+// OrdersByUIDs loads the orders with the given UIDs in one go, in the order of uids. Unknown UIDs are skipped.
+func (s *Storage) OrdersByUIDs(ctx c.Context, uids []string) ([]*models.Order, error) {
+	const op = "storage.postgres.OrdersByUIDs"
+	defer metrics.ObserveQuery(op, time.Now())
+	orders, err := s.ordersByUIDs(ctx, uids)
+	if err != nil {
+		return nil, fmterr(op, err)
+	}
+	return orders, nil
+}
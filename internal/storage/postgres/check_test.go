@@ -0,0 +1,46 @@
+package postgres
+
+import (
+	"database/sql"
+	"l0/internal/models"
+	"l0/internal/storage"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoredCheck(t *testing.T) {
+	t0 := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	at := func(t time.Time) sql.NullTime { return sql.NullTime{Time: t, Valid: true} }
+	hash := func(h string) sql.NullString { return sql.NullString{String: h, Valid: true} }
+	tests := []struct {
+		name    string
+		st      stored
+		version int64
+		hash    string
+		at      sql.NullTime
+		want    error
+	}{
+		{"higher version", stored{version: 1, hash: hash("a")}, 2, "b", sql.NullTime{}, nil},
+		{"lower version", stored{version: 2, hash: hash("a")}, 1, "b", at(t0), storage.ErrStaleOrder},
+		{"same content", stored{version: 1, hash: hash("a")}, 1, "a", sql.NullTime{}, storage.ErrDuplicateMessage},
+		{"saved before versioning", stored{}, 0, "b", at(t0), storage.ErrDuplicateMessage},
+		{"same version, other content", stored{version: 1, hash: hash("a")}, 1, "b", at(t0.Add(time.Hour)), storage.ErrConflictingOrder},
+		{"unversioned, later event", stored{hash: hash("a"), eventTime: at(t0)}, 0, "b", at(t0.Add(time.Second)), nil},
+		{"unversioned, earlier event", stored{hash: hash("a"), eventTime: at(t0)}, 0, "b", at(t0.Add(-time.Second)), storage.ErrStaleOrder},
+		{"unversioned, same event time", stored{hash: hash("a"), eventTime: at(t0)}, 0, "b", at(t0), storage.ErrConflictingOrder},
+		{"unversioned, stored without event", stored{hash: hash("a")}, 0, "b", at(t0), nil},
+		{"unversioned, no event time", stored{hash: hash("a"), eventTime: at(t0)}, 0, "b", sql.NullTime{}, storage.ErrConflictingOrder},
+		{"unversioned, same content", stored{hash: hash("a"), eventTime: at(t0)}, 0, "a", at(t0.Add(time.Second)), storage.ErrDuplicateMessage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.st.check(&models.Order{Version: tt.version}, tt.hash, tt.at)
+			assert.ErrorIs(t, err, tt.want)
+			if tt.want == nil {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package postgres
+
+import (
+	c "context"
+	"database/sql"
+	"errors"
+	"l0/internal/metrics"
+	"l0/internal/storage"
+	"time"
+)
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// GetCustomer returns a customer's profile along with order and address counts.
+func (s *Storage) GetCustomer(ctx c.Context, customerID string) (*storage.Customer, error) {
+	const op = "storage.postgres.GetCustomer"
+	defer metrics.ObserveQuery(op, time.Now())
+
+	var (
+		cust        storage.Customer
+		first, last sql.NullTime
+	)
+	err := s.db.QueryRowContext(ctx, `SELECT u.customer_id, u.name, u.phone, u.email,
+			(SELECT count(*) FROM users_addresses ua WHERE ua.user_id = u.id),
+			count(o.order_uid), min(o.date_created), max(o.date_created)
+		FROM users u
+			LEFT JOIN orders o ON o.customer_id = u.customer_id
+		WHERE u.customer_id = $1
+		GROUP BY u.id`, customerID).Scan(
+		&cust.CustomerID, &cust.Name, &cust.Phone, &cust.Email, &cust.Addresses, &cust.Orders, &first, &last)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmterr(op, storage.ErrCustomerNotFound)
+		}
+		return nil, fmterr(op, err)
+	}
+	cust.FirstOrderAt, cust.LastOrderAt = nullTime(first), nullTime(last)
+	return &cust, nil
+}
+
+// CustomerAddresses returns every address a customer has used, most recently used first.
+func (s *Storage) CustomerAddresses(ctx c.Context, customerID string) ([]storage.Address, error) {
+	const op = "storage.postgres.CustomerAddresses"
+	defer metrics.ObserveQuery(op, time.Now())
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return nil, fmterr(op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var userID int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM users WHERE customer_id = $1`, customerID).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmterr(op, storage.ErrCustomerNotFound)
+		}
+		return nil, fmterr(op, err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT a.id, a.zip, a.city, a.address, a.region, count(o.order_uid), max(o.date_created)
+		FROM users_addresses ua
+			JOIN addresses a ON a.id = ua.address_id
+			LEFT JOIN orders o ON o.delivery = a.id AND o.customer_id = a.customer_id
+		WHERE ua.user_id = $1
+		GROUP BY a.id
+		ORDER BY max(o.date_created) DESC NULLS LAST, a.id`, userID)
+	if err != nil {
+		return nil, fmterr(op, err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	addresses := []storage.Address{}
+	for rows.Next() {
+		var (
+			a    storage.Address
+			last sql.NullTime
+		)
+		if err := rows.Scan(&a.ID, &a.Zip, &a.City, &a.Address, &a.Region, &a.Orders, &last); err != nil {
+			return nil, fmterr(op, err)
+		}
+		a.LastUsedAt = nullTime(last)
+		addresses = append(addresses, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmterr(op, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmterr(op, err)
+	}
+	return addresses, nil
+}
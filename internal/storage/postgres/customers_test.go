@@ -0,0 +1,60 @@
+package postgres_test
+
+import (
+	"context"
+	"l0/internal/storage"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCustomer(t *testing.T) {
+	st := newStorage(t)
+	ctx := context.Background()
+
+	first, second, third := newTestOrder(), newTestOrder(), newTestOrder()
+	first.DateCreated = "2024-01-01T10:00:00Z"
+	second.DateCreated = "2024-02-01T10:00:00Z"
+	third.DateCreated = "2024-03-01T10:00:00Z"
+	second.CustomerID, second.Delivery = first.CustomerID, first.Delivery
+	third.CustomerID, third.Delivery = first.CustomerID, first.Delivery
+	third.Delivery.Address = "Nevsky 1"
+	require.NoError(t, st.SaveOrder(ctx, first))
+	require.NoError(t, st.SaveOrder(ctx, second))
+	require.NoError(t, st.SaveOrder(ctx, third))
+
+	cust, err := st.GetCustomer(ctx, first.CustomerID)
+	require.NoError(t, err)
+	assert.Equal(t, first.CustomerID, cust.CustomerID)
+	assert.Equal(t, first.Delivery.Phone, cust.Phone)
+	assert.Equal(t, 3, cust.Orders)
+	assert.Equal(t, 2, cust.Addresses)
+	require.NotNil(t, cust.FirstOrderAt)
+	require.NotNil(t, cust.LastOrderAt)
+	assert.Equal(t, "2024-01-01T10:00:00Z", cust.FirstOrderAt.Format("2006-01-02T15:04:05Z"))
+	assert.Equal(t, "2024-03-01T10:00:00Z", cust.LastOrderAt.Format("2006-01-02T15:04:05Z"))
+
+	addresses, err := st.CustomerAddresses(ctx, first.CustomerID)
+	require.NoError(t, err)
+	require.Len(t, addresses, 2)
+	assert.Equal(t, "Nevsky 1", addresses[0].Address)
+	assert.Equal(t, 1, addresses[0].Orders)
+	assert.Equal(t, first.Delivery.Address, addresses[1].Address)
+	assert.Equal(t, 2, addresses[1].Orders)
+
+	page, err := st.ListOrders(ctx, storage.OrderFilter{CustomerID: first.CustomerID})
+	require.NoError(t, err)
+	require.Len(t, page.Orders, 3)
+	assert.Equal(t, third.OrderUID, page.Orders[0].OrderUID)
+}
+
+func TestCustomer_NotFound(t *testing.T) {
+	st := newStorage(t)
+	ctx := context.Background()
+
+	_, err := st.GetCustomer(ctx, "nobody")
+	assert.ErrorIs(t, err, storage.ErrCustomerNotFound)
+	_, err = st.CustomerAddresses(ctx, "nobody")
+	assert.ErrorIs(t, err, storage.ErrCustomerNotFound)
+}
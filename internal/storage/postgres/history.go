@@ -0,0 +1,186 @@
+package postgres
+
+import (
+	c "context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"l0/internal/metrics"
+	"l0/internal/models"
+	"l0/internal/storage"
+	"maps"
+	"reflect"
+	"slices"
+	"time"
+)
+
+// appendRevision snapshots an order right after it has been written, diffing it against the previous revision.
+func appendRevision(tx *sql.Tx, order *models.Order, src *storage.Source) error {
+	snapshot, err := json.Marshal(order)
+	if err != nil {
+		return err
+	}
+
+	var (
+		revision int
+		prev     []byte
+	)
+	err = tx.QueryRow(`SELECT revision, snapshot FROM order_revisions WHERE order_uid = $1 ORDER BY revision DESC LIMIT 1`,
+		order.OrderUID).Scan(&revision, &prev)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	diff, err := revisionDiff(prev, snapshot)
+	if err != nil {
+		return err
+	}
+	topic, partition, offset := sourceColumns(src)
+
+	_, err = tx.Exec(`INSERT INTO order_revisions (order_uid, revision, version, snapshot, diff, source_topic, source_partition, source_offset)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		order.OrderUID, revision+1, order.Version, string(snapshot), diff, topic, partition, offset)
+	return err
+}
+
+// revisionDiff diffs a snapshot against the previous one, NULL for the first revision
+func revisionDiff(prev, snapshot []byte) (sql.NullString, error) {
+	if prev == nil {
+		return sql.NullString{}, nil
+	}
+	changes, err := diffJSON(prev, snapshot)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	b, err := json.Marshal(changes)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+// sourceColumns are the source_* columns of a revision, NULL without a source
+func sourceColumns(src *storage.Source) (topic sql.NullString, partition sql.NullInt32, offset sql.NullInt64) {
+	if src != nil {
+		topic = sql.NullString{String: src.Topic, Valid: true}
+		partition = sql.NullInt32{Int32: int32(src.Partition), Valid: true}
+		offset = sql.NullInt64{Int64: src.Offset, Valid: true}
+	}
+	return topic, partition, offset
+}
+
+// OrderHistory returns every revision of an order, oldest first.
+// Orders saved before revisions were kept have none, an empty history is returned for them.
+func (s *Storage) OrderHistory(ctx c.Context, orderUID string) ([]storage.Revision, error) {
+	const op = "storage.postgres.OrderHistory"
+	defer metrics.ObserveQuery(op, time.Now())
+
+	rows, err := s.db.QueryContext(ctx, `SELECT revision, version, snapshot, diff, source_topic, source_partition, source_offset, created_at
+		FROM order_revisions WHERE order_uid = $1 ORDER BY revision`, orderUID)
+	if err != nil {
+		return nil, fmterr(op, err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var revisions []storage.Revision
+	for rows.Next() {
+		var (
+			r         storage.Revision
+			snapshot  []byte
+			diff      []byte
+			topic     sql.NullString
+			partition sql.NullInt32
+			offset    sql.NullInt64
+		)
+		err = rows.Scan(&r.Revision, &r.Version, &snapshot, &diff, &topic, &partition, &offset, &r.CreatedAt)
+		if err != nil {
+			return nil, fmterr(op, err)
+		}
+		if err = json.Unmarshal(snapshot, &r.Order); err != nil {
+			return nil, fmterr(op, err)
+		}
+		if diff != nil {
+			if err = json.Unmarshal(diff, &r.Diff); err != nil {
+				return nil, fmterr(op, err)
+			}
+		}
+		if topic.Valid {
+			r.Source = &storage.Source{Topic: topic.String, Partition: int(partition.Int32), Offset: offset.Int64}
+		}
+		revisions = append(revisions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmterr(op, err)
+	}
+	if len(revisions) > 0 {
+		return revisions, nil
+	}
+
+	var exists bool
+	err = s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM orders WHERE order_uid = $1)`, orderUID).Scan(&exists)
+	if err != nil {
+		return nil, fmterr(op, err)
+	}
+	if !exists {
+		return nil, fmterr(op, storage.ErrOrderNotFound)
+	}
+	return []storage.Revision{}, nil
+}
+
+// diffJSON lists the leaf values that differ between two JSON documents.
+func diffJSON(from, to []byte) ([]storage.Change, error) {
+	var a, b any
+	if err := json.Unmarshal(from, &a); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(to, &b); err != nil {
+		return nil, err
+	}
+	changes := []storage.Change{}
+	diffValues("", a, b, &changes)
+	return changes, nil
+}
+
+func diffValues(path string, a, b any, changes *[]storage.Change) {
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make(map[string]struct{}, len(av)+len(bv))
+		for k := range av {
+			keys[k] = struct{}{}
+		}
+		for k := range bv {
+			keys[k] = struct{}{}
+		}
+		for _, k := range slices.Sorted(maps.Keys(keys)) {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			diffValues(p, av[k], bv[k], changes)
+		}
+		return
+	case []any:
+		bv, ok := b.([]any)
+		if !ok {
+			break
+		}
+		for i := range max(len(av), len(bv)) {
+			var x, y any
+			if i < len(av) {
+				x = av[i]
+			}
+			if i < len(bv) {
+				y = bv[i]
+			}
+			diffValues(fmt.Sprintf("%s[%d]", path, i), x, y, changes)
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		*changes = append(*changes, storage.Change{Path: path, From: a, To: b})
+	}
+}
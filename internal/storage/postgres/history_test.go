@@ -0,0 +1,24 @@
+package postgres
+
+import (
+	"l0/internal/storage"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffJSON(t *testing.T) {
+	from := []byte(`{"version":1,"delivery":{"phone":"+1","city":"A"},"items":[{"status":202},{"status":202}]}`)
+	to := []byte(`{"version":2,"delivery":{"phone":"+2","city":"A"},"items":[{"status":203}],"locale":"en"}`)
+
+	changes, err := diffJSON(from, to)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.Change{
+		{Path: "delivery.phone", From: "+1", To: "+2"},
+		{Path: "items[0].status", From: float64(202), To: float64(203)},
+		{Path: "items[1]", From: map[string]any{"status": float64(202)}, To: nil},
+		{Path: "locale", From: nil, To: "en"},
+		{Path: "version", From: float64(1), To: float64(2)},
+	}, changes)
+}
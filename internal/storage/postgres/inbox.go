@@ -0,0 +1,103 @@
+package postgres
+
+import (
+	c "context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"l0/internal/metrics"
+	"l0/internal/models"
+	"l0/internal/storage"
+	"time"
+)
+
+const (
+	inboxApplied   = "applied"
+	inboxDuplicate = "duplicate"
+	inboxStale     = "stale"
+	inboxConflict  = "conflict"
+	inboxRejected  = "rejected"
+)
+
+// payloadHash hashes the decoded order rather than the raw bytes,
+// so formatting differences between producers don't count as new content.
+func payloadHash(order *models.Order) (string, error) {
+	b, err := json.Marshal(order)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// SaveMessage saves an order received from a broker exactly once.
+// The inbox record is written in the same transaction as the order, so a message
+// replayed after a crash is reported as storage.ErrDuplicateMessage; another payload at the same
+// offset, e.g. after the topic was recreated, is storage.ErrConflictingOrder. Messages the order
+// rejects (see saveOrder) are recorded in the inbox as well and their error is returned.
+func (s *Storage) SaveMessage(ctx c.Context, order *models.Order, src storage.Source) error {
+	const op = "storage.postgres.SaveMessage"
+	defer metrics.ObserveQuery(op, time.Now())
+	hash, err := payloadHash(order)
+	if err != nil {
+		return fmterr(op, err)
+	}
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmterr(op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// same offset seen again: count the delivery and stop
+	var seen string
+	err = tx.QueryRowContext(ctx, `UPDATE inbox SET deliveries = deliveries + 1
+		WHERE topic = $1 AND partition = $2 AND "offset" = $3 RETURNING payload_hash`,
+		src.Topic, src.Partition, src.Offset).Scan(&seen)
+	switch {
+	case err == nil:
+		if err := tx.Commit(); err != nil {
+			return fmterr(op, err)
+		}
+		if seen != hash {
+			return fmterr(op, fmt.Errorf("%w: offset already delivered another payload", storage.ErrConflictingOrder))
+		}
+		return fmterr(op, storage.ErrDuplicateMessage)
+	case !errors.Is(err, sql.ErrNoRows):
+		return fmterr(op, err)
+	}
+
+	// the order itself decides between applied, duplicate, stale, conflicting and rejected content
+	var status string
+	res := saveOrder(tx, order, &src)
+	switch {
+	case res == nil:
+		status = inboxApplied
+	case errors.Is(res, storage.ErrDuplicateMessage):
+		status = inboxDuplicate
+	case errors.Is(res, storage.ErrStaleOrder):
+		status = inboxStale
+	case errors.Is(res, storage.ErrConflictingOrder):
+		status = inboxConflict
+	case errors.Is(res, storage.ErrInvalidTransition):
+		status = inboxRejected
+	default:
+		return fmterr(op, res)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO inbox (topic, partition, "offset", order_uid, payload_hash, status)
+		VALUES ($1, $2, $3, $4, $5, $6)`, src.Topic, src.Partition, src.Offset, order.OrderUID, hash, status)
+	if err != nil {
+		return fmterr(op, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmterr(op, err)
+	}
+	if res != nil {
+		return fmterr(op, res)
+	}
+	return nil
+}
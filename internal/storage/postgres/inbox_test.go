@@ -0,0 +1,101 @@
+package postgres_test
+
+import (
+	"context"
+	"database/sql"
+	"l0/internal/models"
+	"l0/internal/storage"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// inboxRow reads the inbox record of a message
+func inboxRow(t *testing.T, db *sql.DB, src storage.Source) (deliveries int, status string) {
+	t.Helper()
+	err := db.QueryRow(`SELECT deliveries, status FROM inbox WHERE topic = $1 AND partition = $2 AND "offset" = $3`,
+		src.Topic, src.Partition, src.Offset).Scan(&deliveries, &status)
+	require.NoError(t, err)
+	return deliveries, status
+}
+
+func TestSaveMessage(t *testing.T) {
+	st := newStorage(t)
+	db := openDB(t)
+	ctx := context.Background()
+
+	o := newTestOrder()
+	src := storage.Source{Topic: "orders", Partition: 2, Offset: time.Now().UnixNano()}
+	require.NoError(t, st.SaveMessage(ctx, o, src))
+	deliveries, status := inboxRow(t, db, src)
+	assert.Equal(t, 1, deliveries)
+	assert.Equal(t, "applied", status)
+
+	// redelivered after a crash: counted, not applied again
+	require.ErrorIs(t, st.SaveMessage(ctx, o.Clone(), src), storage.ErrDuplicateMessage)
+	deliveries, _ = inboxRow(t, db, src)
+	assert.Equal(t, 2, deliveries)
+	history, err := st.OrderHistory(ctx, o.OrderUID)
+	require.NoError(t, err)
+	assert.Len(t, history, 1)
+
+	// another payload at the same offset is reported, not taken for the message already applied
+	other := o.Clone()
+	other.Version = 1
+	other.Items[0].Status = models.StatusAssembled
+	require.ErrorIs(t, st.SaveMessage(ctx, other, src), storage.ErrConflictingOrder)
+	deliveries, _ = inboxRow(t, db, src)
+	assert.Equal(t, 3, deliveries)
+	requireRoundTrip(t, st, o)
+}
+
+func TestSaveMessage_SameOrderNewOffset(t *testing.T) {
+	st := newStorage(t)
+	db := openDB(t)
+	ctx := context.Background()
+
+	o := newTestOrder()
+	first := storage.Source{Topic: "orders", Partition: 0, Offset: time.Now().UnixNano()}
+	require.NoError(t, st.SaveMessage(ctx, o, first))
+
+	// the producer sent it twice: a message of its own, recorded as a duplicate
+	second := first
+	second.Offset++
+	require.ErrorIs(t, st.SaveMessage(ctx, o.Clone(), second), storage.ErrDuplicateMessage)
+	deliveries, status := inboxRow(t, db, second)
+	assert.Equal(t, 1, deliveries)
+	assert.Equal(t, "duplicate", status)
+}
+
+func TestSaveMessage_Unversioned(t *testing.T) {
+	st := newStorage(t)
+	ctx := context.Background()
+
+	// a producer setting no version: updates go by when they were produced
+	at := time.Now().Truncate(time.Millisecond)
+	src := func(d time.Duration) storage.Source {
+		return storage.Source{Topic: "orders", Offset: at.UnixNano() + int64(d), Time: at.Add(d)}
+	}
+	o := newTestOrder()
+	require.NoError(t, st.SaveMessage(ctx, o, src(0)))
+
+	assembled := o.Clone()
+	assembled.Items[0].Status = models.StatusAssembled
+	require.NoError(t, st.SaveMessage(ctx, assembled, src(time.Minute)))
+	requireRoundTrip(t, st, assembled)
+
+	// produced before the update already applied
+	shipped := o.Clone()
+	shipped.Items[0].Status = models.StatusShipped
+	require.ErrorIs(t, st.SaveMessage(ctx, shipped, src(time.Second)), storage.ErrStaleOrder)
+	requireRoundTrip(t, st, assembled)
+
+	// without an event time it can't be ordered
+	assembled.Items[0].Status = models.StatusShipped
+	assert.ErrorIs(t, st.SaveOrder(ctx, assembled), storage.ErrConflictingOrder)
+
+	require.NoError(t, st.SaveMessage(ctx, shipped, src(2*time.Minute)))
+	requireRoundTrip(t, st, shipped)
+}
@@ -0,0 +1,121 @@
+package postgres
+
+import (
+	c "context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"l0/internal/config"
+	"l0/internal/models"
+	"l0/internal/storage"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// invalidationChannel is the LISTEN/NOTIFY channel replicas share
+const invalidationChannel = "l0_order_invalidations"
+
+// invalidationOf tells replicas which cached keys an order saved anew may have changed
+func invalidationOf(o *models.Order) storage.Invalidation {
+	return storage.Invalidation{
+		OrderUID:    o.OrderUID,
+		Version:     o.Version,
+		TrackNumber: o.TrackNumber,
+		Transaction: o.Payment.Transaction,
+	}
+}
+
+// notifySaved tells every listening replica, this one included, that orders were saved.
+// Postgres delivers the notifications once the transaction commits and drops them if it rolls back,
+// so no write goes unannounced and nothing is announced that wasn't written.
+func notifySaved(tx *sql.Tx, orders ...*models.Order) error {
+	payloads := make([]string, len(orders))
+	for i, o := range orders {
+		b, err := json.Marshal(invalidationOf(o))
+		if err != nil {
+			return err
+		}
+		payloads[i] = string(b)
+	}
+	_, err := tx.Exec(`SELECT pg_notify($1, p) FROM unnest($2::text[]) AS p`, invalidationChannel, pq.Array(payloads))
+	return err
+}
+
+// Listener receives invalidations over a connection of its own, reconnecting when it drops
+type Listener struct {
+	l *pq.Listener
+}
+
+// NewListener connects and starts listening for invalidations
+func NewListener(cfg config.Storage) (*Listener, error) {
+	const op = "storage.postgres.NewListener"
+	l := pq.NewListener(dsn(cfg), time.Second, 30*time.Second, nil)
+	if err := l.Listen(invalidationChannel); err != nil {
+		_ = l.Close()
+		return nil, fmterr(op, err)
+	}
+	return &Listener{l: l}, nil
+}
+
+// Invalidations streams invalidations until ctx is cancelled or the listener is closed.
+// Notifications sent while the connection was down are lost, so a reconnect yields
+// an invalidation of everything. Undecodable payloads are reported on the error channel.
+func (l *Listener) Invalidations(ctx c.Context) (<-chan storage.Invalidation, <-chan error) {
+	const op = "storage.postgres.Listener.Invalidations"
+	invCh := make(chan storage.Invalidation)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(invCh)
+		defer close(errCh)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n, ok := <-l.l.Notify:
+				if !ok {
+					return
+				}
+				inv, err := decodeInvalidation(n)
+				if err != nil {
+					select {
+					case errCh <- fmterr(op, err):
+					default:
+					}
+					continue
+				}
+				select {
+				case invCh <- inv:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return invCh, errCh
+}
+
+// decodeInvalidation reads a notification, nil is what pq sends after a reconnect
+func decodeInvalidation(n *pq.Notification) (storage.Invalidation, error) {
+	if n == nil {
+		return storage.Invalidation{All: true}, nil
+	}
+	var inv storage.Invalidation
+	if err := json.Unmarshal([]byte(n.Extra), &inv); err != nil {
+		return inv, fmt.Errorf("decode %q: %w", n.Extra, err)
+	}
+	return inv, nil
+}
+
+// Ping checks the listener's connection
+func (l *Listener) Ping(c.Context) error {
+	return l.l.Ping()
+}
+
+// Close closes the connection, ending Invalidations
+func (l *Listener) Close() error {
+	return l.l.Close()
+}
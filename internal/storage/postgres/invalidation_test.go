@@ -0,0 +1,76 @@
+package postgres_test
+
+import (
+	"context"
+	"l0/internal/storage"
+	"l0/internal/storage/postgres"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvalidations(t *testing.T) {
+	st := newStorage(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// two replicas listening, one publishing
+	var chans []<-chan storage.Invalidation
+	for range 2 {
+		l, err := postgres.NewListener(*testDB)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = l.Close() })
+		invCh, _ := l.Invalidations(ctx)
+		chans = append(chans, invCh)
+	}
+
+	// quotes and non-ASCII survive the JSON payload
+	o := newTestOrder()
+	o.Payment.Transaction = "tx'\"ü" + o.OrderUID
+	o.Version = 3
+	want := storage.Invalidation{OrderUID: o.OrderUID, Version: 3, TrackNumber: o.TrackNumber, Transaction: o.Payment.Transaction}
+	require.NoError(t, st.SaveOrder(ctx, o))
+
+	for _, invCh := range chans {
+		select {
+		case got := <-invCh:
+			assert.Equal(t, want, got)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no invalidation received")
+		}
+	}
+}
+
+func TestInvalidations_OnlyCommitted(t *testing.T) {
+	st := newStorage(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l, err := postgres.NewListener(*testDB)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	invCh, _ := l.Invalidations(ctx)
+
+	a, b := newTestOrder(), newTestOrder()
+	require.NoError(t, st.SaveMessages(ctx, batchOf(a, b)))
+	// a stale message is recorded in the inbox but its order isn't written, so nothing is announced
+	stale := a.Clone()
+	stale.Version = -1
+	m := batchOf(stale)[0]
+	require.ErrorIs(t, st.SaveMessage(ctx, m.Order, m.Source), storage.ErrStaleOrder)
+	c := newTestOrder()
+	require.NoError(t, st.SaveOrder(ctx, c))
+
+	var got []string
+	for range 3 {
+		select {
+		case inv := <-invCh:
+			got = append(got, inv.OrderUID)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no invalidation received")
+		}
+	}
+	assert.Equal(t, []string{a.OrderUID, b.OrderUID, c.OrderUID}, got)
+}
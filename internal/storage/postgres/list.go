@@ -0,0 +1,125 @@
+package postgres
+
+import (
+	c "context"
+	"encoding/base64"
+	"fmt"
+	"l0/internal/metrics"
+	"l0/internal/storage"
+	"strings"
+	"time"
+)
+
+const defaultListLimit = 20
+
+// cursor points at the last order of a page; the next page starts right after it.
+type cursor struct {
+	created time.Time
+	uid     string
+}
+
+func encodeCursor(cur cursor) string {
+	raw := cur.created.UTC().Format(time.RFC3339Nano) + "|" + cur.uid
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, storage.ErrInvalidCursor
+	}
+	created, uid, ok := strings.Cut(string(raw), "|")
+	if !ok || uid == "" {
+		return cursor{}, storage.ErrInvalidCursor
+	}
+	t, err := time.Parse(time.RFC3339Nano, created)
+	if err != nil {
+		return cursor{}, storage.ErrInvalidCursor
+	}
+	return cursor{created: t, uid: uid}, nil
+}
+
+// ListOrders returns a page of orders matching the filter, newest first.
+func (s *Storage) ListOrders(ctx c.Context, f storage.OrderFilter) (*storage.OrderPage, error) {
+	const op = "storage.postgres.ListOrders"
+	defer metrics.ObserveQuery(op, time.Now())
+	if f.Limit <= 0 {
+		f.Limit = defaultListLimit
+	}
+
+	var (
+		where []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.CustomerID != "" {
+		where = append(where, "o.customer_id = "+arg(f.CustomerID))
+	}
+	if f.DeliveryService != "" {
+		where = append(where, "o.delivery_service = "+arg(f.DeliveryService))
+	}
+	if f.Locale != "" {
+		where = append(where, "o.locale = "+arg(f.Locale))
+	}
+	if !f.CreatedFrom.IsZero() {
+		where = append(where, "o.date_created >= "+arg(f.CreatedFrom.UTC()))
+	}
+	if !f.CreatedTo.IsZero() {
+		where = append(where, "o.date_created < "+arg(f.CreatedTo.UTC()))
+	}
+	if f.Status != nil {
+		where = append(where, "EXISTS (SELECT 1 FROM order_items oi WHERE oi.order_uid = o.order_uid AND oi.status = "+arg(*f.Status)+")")
+	}
+	if f.Cursor != "" {
+		cur, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return nil, fmterr(op, err)
+		}
+		where = append(where, fmt.Sprintf("(o.date_created, o.order_uid) < (%s, %s)", arg(cur.created), arg(cur.uid)))
+	}
+
+	query := `SELECT o.order_uid, o.date_created FROM orders o`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	// fetch one extra row to find out whether there is a next page
+	query += " ORDER BY o.date_created DESC, o.order_uid DESC LIMIT " + arg(f.Limit+1)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmterr(op, err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var page []cursor
+	for rows.Next() {
+		var cur cursor
+		if err := rows.Scan(&cur.uid, &cur.created); err != nil {
+			return nil, fmterr(op, err)
+		}
+		page = append(page, cur)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmterr(op, err)
+	}
+	_ = rows.Close()
+
+	res := &storage.OrderPage{}
+	if len(page) > f.Limit {
+		page = page[:f.Limit]
+		res.NextCursor = encodeCursor(page[len(page)-1])
+	}
+	uids := make([]string, len(page))
+	for i, cur := range page {
+		uids[i] = cur.uid
+	}
+	res.Orders, err = s.ordersByUIDs(ctx, uids)
+	if err != nil {
+		return nil, fmterr(op, err)
+	}
+	return res, nil
+}
@@ -0,0 +1,119 @@
+package postgres_test
+
+import (
+	"context"
+	"l0/internal/models"
+	"l0/internal/storage"
+	"l0/internal/storage/postgres"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// uidsOf lists the uids of a page
+func uidsOf(orders []*models.Order) []string {
+	uids := make([]string, len(orders))
+	for i, o := range orders {
+		uids[i] = o.OrderUID
+	}
+	return uids
+}
+
+// newCustomerOrders saves n orders of a customer of their own, a day apart, and returns their uids newest first
+func newCustomerOrders(t *testing.T, st *postgres.Storage, n int, edit func(i int, o *models.Order)) (string, []string) {
+	t.Helper()
+	first := newTestOrder()
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	uids := make([]string, n)
+	for i := range n {
+		o := newTestOrder()
+		o.CustomerID, o.Delivery = first.CustomerID, first.Delivery
+		o.DateCreated = start.AddDate(0, 0, i).Format("2006-01-02T15:04:05Z")
+		if edit != nil {
+			edit(i, o)
+		}
+		require.NoError(t, st.SaveOrder(context.Background(), o))
+		uids[n-1-i] = o.OrderUID
+	}
+	return first.CustomerID, uids
+}
+
+func TestListOrders_Pages(t *testing.T) {
+	st := newStorage(t)
+	ctx := context.Background()
+	customer, want := newCustomerOrders(t, st, 5, nil)
+
+	var (
+		got   []string
+		pages int
+		f     = storage.OrderFilter{CustomerID: customer, Limit: 2}
+	)
+	for {
+		page, err := st.ListOrders(ctx, f)
+		require.NoError(t, err)
+		pages++
+		assert.LessOrEqual(t, len(page.Orders), 2)
+		got = append(got, uidsOf(page.Orders)...)
+		if page.NextCursor == "" {
+			break
+		}
+		f.Cursor = page.NextCursor
+	}
+	// newest first, every order once, no empty page at the end
+	assert.Equal(t, want, got)
+	assert.Equal(t, 3, pages)
+
+	page, err := st.ListOrders(ctx, storage.OrderFilter{CustomerID: customer, Limit: 5})
+	require.NoError(t, err)
+	assert.Equal(t, want, uidsOf(page.Orders))
+	assert.Empty(t, page.NextCursor, "an exactly full page has no next one")
+}
+
+func TestListOrders_Filters(t *testing.T) {
+	st := newStorage(t)
+	ctx := context.Background()
+	// newest first: 3 is dhl/ru, 2 has a shipped item, 1 and 0 are plain
+	customer, uids := newCustomerOrders(t, st, 4, func(i int, o *models.Order) {
+		switch i {
+		case 2:
+			o.Items[0].Status = models.StatusShipped
+		case 3:
+			o.DeliveryService, o.Locale = "dhl", "ru"
+		}
+	})
+	shipped := models.StatusShipped
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name string
+		f    storage.OrderFilter
+		want []string
+	}{
+		{"customer", storage.OrderFilter{}, uids},
+		{"delivery service", storage.OrderFilter{DeliveryService: "dhl"}, uids[:1]},
+		{"locale", storage.OrderFilter{Locale: "en"}, uids[1:]},
+		{"item status", storage.OrderFilter{Status: &shipped}, uids[1:2]},
+		{"from is inclusive", storage.OrderFilter{CreatedFrom: day(3).Add(10 * time.Hour)}, uids[:2]},
+		{"to is exclusive", storage.OrderFilter{CreatedTo: day(2).Add(10 * time.Hour)}, uids[3:]},
+		{"date range", storage.OrderFilter{CreatedFrom: day(2), CreatedTo: day(4)}, uids[1:3]},
+		{"combined", storage.OrderFilter{Locale: "en", CreatedFrom: day(3)}, uids[1:2]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.f.CustomerID = customer
+			page, err := st.ListOrders(ctx, tt.f)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, uidsOf(page.Orders))
+		})
+	}
+}
+
+func TestListOrders_InvalidCursor(t *testing.T) {
+	st := newStorage(t)
+	for _, cur := range []string{"not base64!", "bm8tc2VwYXJhdG9y", "eWVzdGVyZGF5fGE"} {
+		_, err := st.ListOrders(context.Background(), storage.OrderFilter{Cursor: cur})
+		assert.ErrorIs(t, err, storage.ErrInvalidCursor, cur)
+	}
+}
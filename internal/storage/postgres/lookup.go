@@ -0,0 +1,60 @@
+package postgres
+
+import (
+	c "context"
+	"l0/internal/metrics"
+	"l0/internal/models"
+	"l0/internal/storage"
+	"time"
+)
+
+// OrdersByTrack returns every order with a track number, newest first.
+func (s *Storage) OrdersByTrack(ctx c.Context, track string) ([]*models.Order, error) {
+	const op = "storage.postgres.OrdersByTrack"
+	defer metrics.ObserveQuery(op, time.Now())
+	orders, err := s.ordersWhere(ctx, `track_number = $1`, track)
+	if err != nil {
+		return nil, fmterr(op, err)
+	}
+	return orders, nil
+}
+
+// OrdersByTransaction returns every order paid by a transaction, newest first.
+func (s *Storage) OrdersByTransaction(ctx c.Context, tx string) ([]*models.Order, error) {
+	const op = "storage.postgres.OrdersByTransaction"
+	defer metrics.ObserveQuery(op, time.Now())
+	orders, err := s.ordersWhere(ctx, `payment = $1`, tx)
+	if err != nil {
+		return nil, fmterr(op, err)
+	}
+	return orders, nil
+}
+
+// ordersWhere loads the orders matching a condition on the orders table, or fails with storage.ErrOrderNotFound.
+func (s *Storage) ordersWhere(ctx c.Context, cond string, args ...any) ([]*models.Order, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT order_uid FROM orders WHERE `+cond+` ORDER BY date_created DESC, order_uid DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var uids []string
+	for rows.Next() {
+		var uid string
+		if err := rows.Scan(&uid); err != nil {
+			return nil, err
+		}
+		uids = append(uids, uid)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	orders, err := s.ordersByUIDs(ctx, uids)
+	if err != nil {
+		return nil, err
+	}
+	if len(orders) == 0 {
+		return nil, storage.ErrOrderNotFound
+	}
+	return orders, nil
+}
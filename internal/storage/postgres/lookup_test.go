@@ -0,0 +1,81 @@
+package postgres_test
+
+import (
+	"context"
+	"l0/internal/models"
+	"l0/internal/storage"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrdersByTrackAndTransaction(t *testing.T) {
+	st := newStorage(t)
+	ctx := context.Background()
+
+	a, b := newTestOrder(), newTestOrder()
+	b.TrackNumber = a.TrackNumber
+	b.DateCreated = "2022-01-01T00:00:00Z"
+	require.NoError(t, st.SaveOrder(ctx, a))
+	require.NoError(t, st.SaveOrder(ctx, b))
+
+	got, err := st.OrdersByTrack(ctx, a.TrackNumber)
+	require.NoError(t, err)
+	assert.Equal(t, []*models.Order{b, a}, got)
+
+	got, err = st.OrdersByTransaction(ctx, a.Payment.Transaction)
+	require.NoError(t, err)
+	assert.Equal(t, []*models.Order{a}, got)
+
+	_, err = st.OrdersByTrack(ctx, "unknown")
+	assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+	_, err = st.OrdersByTransaction(ctx, "unknown")
+	assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+}
+
+func TestOrdersByUIDs(t *testing.T) {
+	st := newStorage(t)
+	ctx := context.Background()
+
+	a, b := newTestOrder(), newTestOrder()
+	require.NoError(t, st.SaveOrder(ctx, a))
+	require.NoError(t, st.SaveOrder(ctx, b))
+
+	got, err := st.OrdersByUIDs(ctx, []string{b.OrderUID, "unknown", a.OrderUID})
+	require.NoError(t, err)
+	assert.Equal(t, []*models.Order{b, a}, got)
+}
+
+func TestOrdersSavedSince(t *testing.T) {
+	st := newStorage(t)
+	ctx := context.Background()
+
+	a, before := newTestOrder(), newTestOrder()
+	require.NoError(t, st.SaveOrder(ctx, a))
+	require.NoError(t, st.SaveOrder(ctx, before))
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	b := newTestOrder()
+	require.NoError(t, st.SaveOrder(ctx, b))
+	a.Version = 1
+	a.Items[0].Status = models.StatusAssembled
+	require.NoError(t, st.SaveOrder(ctx, a))
+
+	var got []*models.Order
+	for batch, err := range st.OrdersSavedSince(ctx, since, 10, 1) {
+		require.NoError(t, err)
+		got = append(got, batch...)
+	}
+	assert.Equal(t, []*models.Order{b, a}, got) // the most recently saved last
+
+	got = nil
+	for batch, err := range st.OrdersSavedSince(ctx, since, 1, 1) {
+		require.NoError(t, err)
+		got = append(got, batch...)
+	}
+	assert.Equal(t, []*models.Order{a}, got)
+}
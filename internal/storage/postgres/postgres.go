@@ -6,8 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"l0/internal/config"
+	"l0/internal/metrics"
 	"l0/internal/models"
 	"l0/internal/storage"
+	"time"
 
 	"golang.org/x/net/context"
 )
@@ -28,23 +30,48 @@ func (s *Storage) begin(ctx c.Context) (*sql.Tx, error) {
 // SaveOrder saves an order.
 func (s *Storage) SaveOrder(ctx c.Context, order *models.Order) error {
 	const op = "storage.postgres.SaveOrder"
+	defer metrics.ObserveQuery(op, time.Now())
 	tx, err := s.begin(ctx)
 	if err != nil {
 		return fmterr(op, err)
 	}
 	defer func() { _ = tx.Rollback() }()
 
-	// check if user exists
+	if err = saveOrder(tx, order, nil); err != nil {
+		return fmterr(op, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmterr(op, err)
+	}
+	return nil
+}
+
+// saveOrder writes an order within an existing transaction. An existing order is replaced
+// only by a higher version, or a later event for orders without one (see stored.check),
+// whose item statuses follow models.CanTransition; otherwise
+// storage.ErrStaleOrder, storage.ErrDuplicateMessage, storage.ErrConflictingOrder or
+// storage.ErrInvalidTransition is returned before anything is written.
+// Every accepted write appends a revision to the order history, src is recorded there if set,
+// and notifies replicas once the transaction commits.
+func saveOrder(tx *sql.Tx, order *models.Order, src *storage.Source) error {
+	hash, err := payloadHash(order)
+	if err != nil {
+		return err
+	}
+	at := eventTime(src)
+	if err = checkUpdate(tx, order, hash, at); err != nil {
+		return err
+	}
+
+	// create or update user
 	var (
 		uid uint
 	)
-	err = tx.QueryRow(`INSERT INTO users (customer_id, name, phone, email) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING RETURNING id`,
+	err = tx.QueryRow(`INSERT INTO users (customer_id, name, phone, email) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (customer_id) DO UPDATE SET name = EXCLUDED.name, phone = EXCLUDED.phone, email = EXCLUDED.email RETURNING id`,
 		order.CustomerID, order.Delivery.Name, order.Delivery.Phone, order.Delivery.Email).Scan(&uid)
-	if errors.Is(err, sql.ErrNoRows) {
-		err = tx.QueryRow(`SELECT id FROM users WHERE customer_id = $1`, order.CustomerID).Scan(&uid)
-	}
 	if err != nil {
-		return fmterr(op, err)
+		return err
 	}
 
 	// check address
@@ -56,53 +83,144 @@ func (s *Storage) SaveOrder(ctx c.Context, order *models.Order) error {
 			order.CustomerID, order.Delivery.Zip, order.Delivery.City, order.Delivery.Address, order.Delivery.Region).Scan(&addrID)
 	}
 	if err != nil {
-		return fmterr(op, err)
+		return err
 	}
 
 	// check correlation between user and address
 	_, err = tx.Exec(`INSERT INTO users_addresses (user_id, address_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, uid, addrID)
 	if err != nil {
-		return fmterr(op, err)
+		return err
 	}
 
-	// create payment
+	// create or update payment
 	p := order.Payment
 	_, err = tx.Exec(`INSERT INTO payments 
     (transaction, request_id, currency, provider, amount, payment_dt, bank, delivery_cost, goods_total, custom_fee)
-    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) ON CONFLICT DO NOTHING`,
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+    ON CONFLICT (transaction) DO UPDATE SET request_id = EXCLUDED.request_id, currency = EXCLUDED.currency,
+        provider = EXCLUDED.provider, amount = EXCLUDED.amount, payment_dt = EXCLUDED.payment_dt, bank = EXCLUDED.bank,
+        delivery_cost = EXCLUDED.delivery_cost, goods_total = EXCLUDED.goods_total, custom_fee = EXCLUDED.custom_fee`,
 		p.Transaction, p.RequestID, p.Currency, p.Provider, p.Amount, p.PaymentDT, p.Bank, p.DeliveryCost, p.GoodsTotal, p.CustomFee)
 	if err != nil {
-		return fmterr(op, err)
+		return err
+	}
+
+	// items are replaced as a whole
+	_, err = tx.Exec(`DELETE FROM order_items WHERE order_uid = $1`, order.OrderUID)
+	if err != nil {
+		return err
 	}
 
-	// create order
+	// create or update order
 	_, err = tx.Exec(`INSERT INTO orders 
-    (order_uid, track_number, entry, delivery, payment, locale, internal_signature, customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard)
-    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) ON CONFLICT DO NOTHING`,
+    (order_uid, track_number, entry, delivery, payment, locale, internal_signature, customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard, version, payload_hash, event_time)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+    ON CONFLICT (order_uid) DO UPDATE SET track_number = EXCLUDED.track_number, entry = EXCLUDED.entry, delivery = EXCLUDED.delivery,
+        payment = EXCLUDED.payment, locale = EXCLUDED.locale, internal_signature = EXCLUDED.internal_signature,
+        customer_id = EXCLUDED.customer_id, delivery_service = EXCLUDED.delivery_service, shardkey = EXCLUDED.shardkey,
+        sm_id = EXCLUDED.sm_id, date_created = EXCLUDED.date_created, oof_shard = EXCLUDED.oof_shard,
+        version = EXCLUDED.version, payload_hash = EXCLUDED.payload_hash, event_time = EXCLUDED.event_time`,
 		order.OrderUID, order.TrackNumber, order.Entry, addrID, p.Transaction, order.Locale, order.InternalSignature, order.CustomerID, order.DeliveryService,
-		order.ShardKey, order.SmID, order.DateCreated, order.OofShard)
+		order.ShardKey, order.SmID, order.DateCreated, order.OofShard, order.Version, hash, at)
 	if err != nil {
-		return fmterr(op, err)
+		return err
 	}
 
-	// check all items for existence
-	items := order.Items
-	for _, item := range items {
-		// ensure each item exists
-		_, err = tx.Exec(`INSERT INTO items (nm_id, chrt_id, price, name, size, brand) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT DO NOTHING`,
-			item.NmID, item.ChrtID, item.Price, item.Name, item.Size, item.Brand)
+	// every line keeps the item as it was ordered, nothing is shared with other orders
+	for i, item := range order.Items {
+		_, err = tx.Exec(`INSERT INTO order_items
+    (order_uid, line, nm_id, chrt_id, track_number, price, rid, name, sale, size, total_price, brand, status)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+			order.OrderUID, i, item.NmID, item.ChrtID, item.TrackNumber, item.Price, item.RID, item.Name, item.Sale,
+			item.Size, item.TotalPrice, item.Brand, item.Status)
 		if err != nil {
-			return fmterr(op, err)
+			return err
 		}
+	}
+	if err = appendRevision(tx, order, src); err != nil {
+		return err
+	}
+	return notifySaved(tx, order)
+}
 
-		_, err = tx.Exec(`INSERT INTO order_items (order_uid, item_id, rid, track_number, sale, total_price, status) VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT DO NOTHING`,
-			order.OrderUID, item.NmID, item.RID, order.TrackNumber, item.Sale, item.TotalPrice, item.Status)
-		if err != nil {
-			return fmterr(op, err)
+// checkUpdate compares an incoming order with the stored one, locking its row.
+func checkUpdate(tx *sql.Tx, order *models.Order, hash string, at sql.NullTime) error {
+	var st stored
+	err := tx.QueryRow(`SELECT version, payload_hash, event_time FROM orders WHERE order_uid = $1 FOR UPDATE`,
+		order.OrderUID).Scan(&st.version, &st.hash, &st.eventTime)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	case err != nil:
+		return err
+	}
+	if err = st.check(order, hash, at); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT rid, status FROM order_items WHERE order_uid = $1`, order.OrderUID)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+	statuses := make(map[string]int)
+	for rows.Next() {
+		var (
+			rid    string
+			status int
+		)
+		if err := rows.Scan(&rid, &status); err != nil {
+			return err
 		}
+		statuses[rid] = status
 	}
-	if err := tx.Commit(); err != nil {
-		return fmterr(op, err)
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return checkTransitions(order, statuses)
+}
+
+// stored is the version of an order already saved
+type stored struct {
+	version   int64
+	hash      sql.NullString
+	eventTime sql.NullTime // when the message it was saved from was produced, NULL if not saved from one
+}
+
+// check lets only a higher version replace the stored one. Producers that set no version leave
+// every update at 0: those are ordered by event time, the later message wins, and one with
+// an event time replaces an order stored without. Otherwise different content is a conflict.
+func (st stored) check(order *models.Order, hash string, at sql.NullTime) error {
+	switch {
+	case order.Version < st.version:
+		return storage.ErrStaleOrder
+	case order.Version > st.version:
+		return nil
+	// orders saved before versioning have no hash to compare with, keep them as they are
+	case !st.hash.Valid || st.hash.String == hash:
+		return storage.ErrDuplicateMessage
+	case order.Version == 0 && at.Valid && (!st.eventTime.Valid || at.Time.After(st.eventTime.Time)):
+		return nil
+	case order.Version == 0 && at.Valid && at.Time.Before(st.eventTime.Time):
+		return storage.ErrStaleOrder
+	}
+	return storage.ErrConflictingOrder
+}
+
+// eventTime is when the message an order came from was produced, NULL if unknown
+func eventTime(src *storage.Source) sql.NullTime {
+	if src == nil || src.Time.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: src.Time, Valid: true}
+}
+
+// checkTransitions checks the stored item statuses, by rid, can move to the incoming ones
+func checkTransitions(order *models.Order, statuses map[string]int) error {
+	for _, item := range order.Items {
+		if from, ok := statuses[item.RID]; ok && !models.CanTransition(from, item.Status) {
+			return fmt.Errorf("%w: item %s from %d to %d", storage.ErrInvalidTransition, item.RID, from, item.Status)
+		}
 	}
 	return nil
 }
@@ -110,6 +228,7 @@ func (s *Storage) SaveOrder(ctx c.Context, order *models.Order) error {
 // GetOrder gets an order.
 func (s *Storage) GetOrder(ctx c.Context, orderUID string) (_ *models.Order, err error) {
 	const op = "storage.postgres.GetOrder"
+	defer metrics.ObserveQuery(op, time.Now())
 	order := models.Order{}
 	tx, err := s.begin(ctx)
 	if err != nil {
@@ -122,8 +241,8 @@ func (s *Storage) GetOrder(ctx c.Context, orderUID string) (_ *models.Order, err
 		transaction string
 	)
 	err = tx.QueryRow(`SELECT order_uid, track_number, entry, delivery, payment, locale, internal_signature,
-       customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard FROM orders WHERE order_uid = $1`, orderUID).Scan(
-		&order.OrderUID, &order.TrackNumber, &order.Entry, &deliveryID, &transaction, &order.Locale, &order.InternalSignature, &order.CustomerID, &order.DeliveryService, &order.ShardKey, &order.SmID, &order.DateCreated, &order.OofShard,
+       customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard, version FROM orders WHERE order_uid = $1`, orderUID).Scan(
+		&order.OrderUID, &order.TrackNumber, &order.Entry, &deliveryID, &transaction, &order.Locale, &order.InternalSignature, &order.CustomerID, &order.DeliveryService, &order.ShardKey, &order.SmID, &order.DateCreated, &order.OofShard, &order.Version,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -154,11 +273,8 @@ func (s *Storage) GetOrder(ctx c.Context, orderUID string) (_ *models.Order, err
 	order.Delivery = d
 
 	// get all items out
-	items, err := tx.Query(`SELECT  i.chrt_id, oi.track_number, i.price, oi.rid, i.name, oi.sale, i.size, oi.total_price, i.nm_id, i.brand, oi.status
-				FROM order_items oi
-				JOIN items i ON i.nm_id = oi.item_id
-				WHERE oi.track_number = $1;
-	`, order.TrackNumber)
+	items, err := tx.Query(`SELECT chrt_id, track_number, price, rid, name, sale, size, total_price, nm_id, brand, status
+		FROM order_items WHERE order_uid = $1 ORDER BY line`, order.OrderUID)
 	if err != nil {
 		return nil, fmterr(op, err)
 	}
@@ -180,42 +296,55 @@ func (s *Storage) GetOrder(ctx c.Context, orderUID string) (_ *models.Order, err
 	return &order, nil
 }
 
-// NewStorage initializes the storage.
-func NewStorage(s config.Storage) (*Storage, error) {
-	const op = "storage.postgres.NewStorage"
-	url := fmt.Sprintf(
+func dsn(s config.Storage) string {
+	return fmt.Sprintf(
 		"postgres://%s:%s@%s:%d/%s?sslmode=%s",
 		s.User, s.Password, s.Host, s.Port, s.DBName, s.SSLMode,
 	)
-	db, err := sql.Open("postgres", url)
+}
+
+// NewStorage initializes the storage.
+func NewStorage(s config.Storage) (*Storage, error) {
+	const op = "storage.postgres.NewStorage"
+	db, err := sql.Open("postgres", dsn(s))
 	if err != nil {
 		return nil, fmterr(op, err)
 	}
 	return &Storage{db: db}, db.Ping()
 }
 
+// Ping checks that the database is reachable.
+func (s *Storage) Ping(ctx c.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close closes the database connection pool.
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
 // AllOrders fetches all orders from the database and returns them
 func (s *Storage) AllOrders(ctx context.Context) ([]*models.Order, error) {
 	const op = "storage.postgres.AllOrders"
-	uids, err := s.db.QueryContext(ctx, `SELECT order_uid FROM orders ORDER BY order_uid`)
+	defer metrics.ObserveQuery(op, time.Now())
+	rows, err := s.db.QueryContext(ctx, `SELECT order_uid FROM orders ORDER BY order_uid`)
 	if err != nil {
 		return nil, fmterr(op, err)
 	}
-	defer func() { _ = uids.Close() }()
-	var orders []*models.Order
-	for uids.Next() {
+	defer func() { _ = rows.Close() }()
+	var uids []string
+	for rows.Next() {
 		var uid string
-		err = uids.Scan(&uid)
-		if err != nil {
-			return nil, fmterr(op, err)
-		}
-		order, err := s.GetOrder(ctx, uid)
-		if err != nil {
+		if err = rows.Scan(&uid); err != nil {
 			return nil, fmterr(op, err)
 		}
-		orders = append(orders, order)
+		uids = append(uids, uid)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmterr(op, err)
 	}
-	if err := uids.Err(); err != nil {
+	orders, err := s.ordersByUIDs(ctx, uids)
+	if err != nil {
 		return nil, fmterr(op, err)
 	}
 	return orders, nil
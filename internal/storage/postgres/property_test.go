@@ -0,0 +1,196 @@
+package postgres_test
+
+import (
+	"context"
+	crand "crypto/rand"
+	"fmt"
+	"hash/fnv"
+	"l0/internal/models"
+	"math"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/require"
+)
+
+// unicode, quotes and backslashes on purpose; no NUL, Postgres rejects it in text
+var alphabet = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789 '\"\\%_-,.;ёжЖüß漢🙂")
+
+var statuses = []int{models.StatusCreated, models.StatusAccepted, models.StatusAssembled, models.StatusShipped,
+	models.StatusDelivered, models.StatusCancelled, models.StatusReturned}
+
+func randText(r *rand.Rand, maxLen int) string {
+	b := make([]rune, 1+r.IntN(maxLen))
+	for i := range b {
+		b[i] = alphabet[r.IntN(len(alphabet))]
+	}
+	return string(b)
+}
+
+// maybeText is randText or, half of the time, empty
+func maybeText(r *rand.Rand, maxLen int) string {
+	if r.IntN(2) == 0 {
+		return ""
+	}
+	return randText(r, maxLen)
+}
+
+func randLetters(r *rand.Rand, n int, from string) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = from[r.IntN(len(from))]
+	}
+	return string(b)
+}
+
+func randInt(r *rand.Rand) int {
+	return 1 + r.IntN(math.MaxInt32-1)
+}
+
+type customer struct {
+	id, name, phone, email string
+	addresses              []models.Delivery
+}
+
+// genOrders generates n valid orders where customers place several orders, customers share
+// a street address, orders share track numbers and items repeat within and across orders.
+// The same r gives the same orders up to their IDs, which are unique per call.
+func genOrders(r *rand.Rand, n int) []*models.Order {
+	prefix := crand.Text()[:12]
+
+	shared := models.Delivery{
+		Zip:     randLetters(r, 1+r.IntN(20), "0123456789"),
+		City:    randText(r, 100),
+		Address: randText(r, 200),
+		Region:  randText(r, 100),
+	}
+	customers := make([]*customer, 1+r.IntN(max(n/2, 1)))
+	for i := range customers {
+		id := fmt.Sprintf("%sc%d", prefix, i)
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(id))
+		c := &customer{
+			id:    id,
+			name:  randText(r, 255),
+			phone: fmt.Sprintf("+7%010d", h.Sum64()%1e10), // unique across the whole database
+			email: id + "@example.com",
+		}
+		for range 1 + r.IntN(2) {
+			c.addresses = append(c.addresses, models.Delivery{
+				Zip:     randLetters(r, 1+r.IntN(20), "0123456789"),
+				City:    randText(r, 100),
+				Address: randText(r, 200),
+				Region:  randText(r, 100),
+			})
+		}
+		if r.IntN(3) == 0 {
+			c.addresses = append(c.addresses, shared)
+		}
+		customers[i] = c
+	}
+	catalogue := make([]int64, 1+r.IntN(4))
+	for i := range catalogue {
+		catalogue[i] = 1 + r.Int64N(math.MaxInt64-1)
+	}
+
+	orders := make([]*models.Order, n)
+	for i := range orders {
+		uid := fmt.Sprintf("%so%d", prefix, i)
+		c := customers[r.IntN(len(customers))]
+		d := c.addresses[r.IntN(len(c.addresses))]
+		d.Name, d.Phone, d.Email = c.name, c.phone, c.email
+
+		track := randText(r, 30)
+		if i > 0 && r.IntN(4) == 0 {
+			track = orders[r.IntN(i)].TrackNumber
+		}
+		o := &models.Order{
+			OrderUID:    uid,
+			TrackNumber: track,
+			Entry:       randText(r, 50),
+			Delivery:    d,
+			Payment: models.Payment{
+				Transaction:  uid,
+				RequestID:    maybeText(r, 20),
+				Currency:     randLetters(r, 3, "ABCDEFGHIJKLMNOPQRSTUVWXYZ"),
+				Provider:     randText(r, 100),
+				Amount:       randInt(r),
+				PaymentDT:    1 + r.Int64N(math.MaxInt64-1),
+				Bank:         randText(r, 100),
+				DeliveryCost: randInt(r),
+				GoodsTotal:   randInt(r),
+				CustomFee:    r.IntN(1000),
+			},
+			Locale:            randLetters(r, 1+r.IntN(8), "abcdefghijklmnopqrstuvwxyz"),
+			InternalSignature: maybeText(r, 50),
+			CustomerID:        c.id,
+			DeliveryService:   randText(r, 50),
+			ShardKey:          randText(r, 10),
+			SmID:              randInt(r),
+			DateCreated:       time.Unix(r.Int64N(4102444800), 0).UTC().Format("2006-01-02T15:04:05Z"),
+			OofShard:          randText(r, 10),
+			Version:           r.Int64N(1 << 40),
+		}
+		for range 1 + r.IntN(5) {
+			item := models.Item{
+				ChrtID:      1 + r.Int64N(math.MaxInt64-1),
+				TrackNumber: track,
+				Price:       randInt(r),
+				RID:         randText(r, 40),
+				Name:        randText(r, 255),
+				Sale:        r.IntN(100),
+				Size:        randText(r, 50),
+				TotalPrice:  randInt(r),
+				NmID:        catalogue[r.IntN(len(catalogue))],
+				Brand:       randText(r, 255),
+				Status:      statuses[r.IntN(len(statuses))],
+			}
+			if len(o.Items) > 0 && r.IntN(4) == 0 {
+				item = o.Items[r.IntN(len(o.Items))]
+				item.RID = randText(r, 40)
+			}
+			if r.IntN(5) == 0 {
+				item.TrackNumber = randText(r, 30)
+			}
+			o.Items = append(o.Items, item)
+		}
+		orders[i] = o
+	}
+	return orders
+}
+
+func saveAndCompare(t *testing.T, orders []*models.Order) {
+	t.Helper()
+	st := newStorage(t)
+	v := validator.New()
+	for _, o := range orders {
+		require.NoError(t, v.Struct(o))
+		require.NoError(t, st.SaveOrder(context.Background(), o))
+	}
+	requireRoundTrip(t, st, orders...)
+}
+
+// TestSaveOrder_RandomOrders runs with a fresh seed each time; rerun a failure with TEST_SEED
+func TestSaveOrder_RandomOrders(t *testing.T) {
+	seed := uint64(time.Now().UnixNano())
+	if s := os.Getenv("TEST_SEED"); s != "" {
+		var err error
+		seed, err = strconv.ParseUint(s, 10, 64)
+		require.NoError(t, err)
+	}
+	t.Logf("seed %d", seed)
+	saveAndCompare(t, genOrders(rand.New(rand.NewPCG(seed, seed)), 200))
+}
+
+func FuzzSaveOrder(f *testing.F) {
+	f.Add(uint64(0), uint8(1))
+	f.Add(uint64(42), uint8(8))
+	f.Add(uint64(1337), uint8(16))
+	f.Fuzz(func(t *testing.T, seed uint64, n uint8) {
+		saveAndCompare(t, genOrders(rand.New(rand.NewPCG(seed, seed)), 1+int(n%16)))
+	})
+}
@@ -0,0 +1,187 @@
+package postgres_test
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"l0/internal/models"
+	"l0/internal/storage"
+	"l0/internal/storage/postgres"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func randDigits(n int) string {
+	v, _ := rand.Int(rand.Reader, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil))
+	return fmt.Sprintf("%0*d", n, v)
+}
+
+// newTestOrder returns a valid order with its own customer, payment and track number
+func newTestOrder() *models.Order {
+	uid := rand.Text()
+	track := "WB" + uid[:10]
+	return &models.Order{
+		OrderUID:    uid,
+		TrackNumber: track,
+		Entry:       "WBIL",
+		Delivery: models.Delivery{
+			Name:    "Test Testov",
+			Phone:   "+7" + randDigits(10),
+			Zip:     "2639809",
+			City:    "Kiryat Mozkin",
+			Address: "Ploshad Mira 15",
+			Region:  "Kraiot",
+			Email:   uid + "@gmail.com",
+		},
+		Payment: models.Payment{
+			Transaction:  uid,
+			Currency:     "USD",
+			Provider:     "wbpay",
+			Amount:       1817,
+			PaymentDT:    1637907727,
+			Bank:         "alpha",
+			DeliveryCost: 1500,
+			GoodsTotal:   317,
+		},
+		Items: []models.Item{{
+			ChrtID:      9934930,
+			TrackNumber: track,
+			Price:       453,
+			RID:         "ab4219087a764ae0b" + uid[:6],
+			Name:        "Mascaras",
+			Sale:        30,
+			Size:        "0",
+			TotalPrice:  317,
+			NmID:        2389212,
+			Brand:       "Vivienne Sabo",
+			Status:      models.StatusAccepted,
+		}},
+		Locale:          "en",
+		CustomerID:      "test" + uid[:8],
+		DeliveryService: "meest",
+		ShardKey:        "9",
+		SmID:            99,
+		DateCreated:     "2021-11-26T06:22:19Z",
+		OofShard:        "1",
+	}
+}
+
+// requireRoundTrip checks that every order reads back exactly as saved, one by one and in bulk
+func requireRoundTrip(t *testing.T, st *postgres.Storage, orders ...*models.Order) {
+	t.Helper()
+	ctx := context.Background()
+	for _, o := range orders {
+		got, err := st.GetOrder(ctx, o.OrderUID)
+		require.NoError(t, err)
+		assert.Equal(t, o, got)
+	}
+
+	all, err := st.AllOrders(ctx)
+	require.NoError(t, err)
+	byUID := make(map[string]*models.Order, len(all))
+	for _, o := range all {
+		byUID[o.OrderUID] = o
+	}
+	for _, o := range orders {
+		assert.Equal(t, o, byUID[o.OrderUID])
+	}
+}
+
+func TestSaveOrder_RoundTrip(t *testing.T) {
+	st := newStorage(t)
+	o := newTestOrder()
+	require.NoError(t, st.SaveOrder(context.Background(), o))
+	requireRoundTrip(t, st, o)
+}
+
+func TestSaveOrder_SharedNmID(t *testing.T) {
+	st := newStorage(t)
+	ctx := context.Background()
+
+	a, b := newTestOrder(), newTestOrder()
+	b.Items[0].NmID = a.Items[0].NmID
+	b.Items[0].ChrtID = a.Items[0].ChrtID + 1
+	b.Items[0].Price = a.Items[0].Price * 2
+	b.Items[0].Name = "Lipstick"
+	b.Items[0].Size = "M"
+	b.Items[0].Brand = "Other"
+
+	require.NoError(t, st.SaveOrder(ctx, a))
+	require.NoError(t, st.SaveOrder(ctx, b))
+	requireRoundTrip(t, st, a, b)
+}
+
+func TestSaveOrder_SharedTrackNumber(t *testing.T) {
+	st := newStorage(t)
+	ctx := context.Background()
+
+	a, b := newTestOrder(), newTestOrder()
+	b.TrackNumber = a.TrackNumber
+	b.Items[0].TrackNumber = a.TrackNumber
+
+	require.NoError(t, st.SaveOrder(ctx, a))
+	require.NoError(t, st.SaveOrder(ctx, b))
+	requireRoundTrip(t, st, a, b)
+}
+
+func TestSaveOrder_RepeatedItem(t *testing.T) {
+	st := newStorage(t)
+	o := newTestOrder()
+	second := o.Items[0]
+	second.RID += "2"
+	second.Price = 1
+	second.TrackNumber = "WBSPLIT"
+	third := o.Items[0]
+	third.RID += "3"
+	o.Items = append(o.Items, second, third)
+
+	require.NoError(t, st.SaveOrder(context.Background(), o))
+	requireRoundTrip(t, st, o)
+}
+
+func TestSaveOrder_NewVersionReplacesItems(t *testing.T) {
+	st := newStorage(t)
+	ctx := context.Background()
+
+	o := newTestOrder()
+	extra := o.Items[0]
+	extra.RID += "2"
+	extra.NmID++
+	o.Items = append(o.Items, extra)
+	require.NoError(t, st.SaveOrder(ctx, o))
+
+	updated := *o
+	updated.Version = 1
+	updated.Items = []models.Item{o.Items[1]}
+	updated.Items[0].Status = models.StatusShipped
+	require.NoError(t, st.SaveOrder(ctx, &updated))
+	requireRoundTrip(t, st, &updated)
+}
+
+func TestOrderHistory_BeforeRevisions(t *testing.T) {
+	st := newStorage(t)
+	ctx := context.Background()
+
+	o := newTestOrder()
+	require.NoError(t, st.SaveOrder(ctx, o))
+	// as if the order was saved before order_revisions existed: the table is append-only,
+	// its trigger is bypassed for this transaction only
+	tx, err := openDB(t).BeginTx(ctx, nil)
+	require.NoError(t, err)
+	_, err = tx.ExecContext(ctx, `SET LOCAL session_replication_role = replica`)
+	require.NoError(t, err)
+	_, err = tx.ExecContext(ctx, `DELETE FROM order_revisions WHERE order_uid = $1`, o.OrderUID)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	history, err := st.OrderHistory(ctx, o.OrderUID)
+	require.NoError(t, err)
+	assert.NotNil(t, history)
+	assert.Empty(t, history)
+
+	_, err = st.OrderHistory(ctx, rand.Text())
+	assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+}
@@ -0,0 +1,422 @@
+package postgres
+
+import (
+	c "context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"l0/internal/metrics"
+	"l0/internal/models"
+	"l0/internal/storage"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MaxBatchSize is the most messages SaveMessages takes, a query has 65535 parameters at most and an order takes 16
+const MaxBatchSize = 65535 / 16
+
+// SaveMessages saves orders received from a broker in a single transaction, writing every table
+// with a multi-row insert or COPY instead of a round trip per row. Only batches that apply cleanly
+// as a whole are taken: an order repeated in the batch, a message already in the inbox or an update
+// SaveMessage would skip or reject makes it return storage.ErrBatchRejected with nothing written,
+// so the caller can save the messages one by one and tell which is which.
+func (s *Storage) SaveMessages(ctx c.Context, msgs []storage.Message) error {
+	const op = "storage.postgres.SaveMessages"
+	defer metrics.ObserveQuery(op, time.Now())
+	if len(msgs) == 0 {
+		return nil
+	}
+	if len(msgs) > MaxBatchSize {
+		return fmterr(op, fmt.Errorf("%w: %d messages, %d at most", storage.ErrBatchRejected, len(msgs), MaxBatchSize))
+	}
+
+	uids := make([]string, len(msgs))
+	hashes := make([]string, len(msgs))
+	seen := make(map[string]bool, len(msgs))
+	for i, m := range msgs {
+		uid := m.Order.OrderUID
+		if seen[uid] {
+			// a later version would have to see the earlier one written first
+			return fmterr(op, fmt.Errorf("%w: order %s repeated", storage.ErrBatchRejected, uid))
+		}
+		seen[uid] = true
+		hash, err := payloadHash(m.Order)
+		if err != nil {
+			return fmterr(op, err)
+		}
+		uids[i], hashes[i] = uid, hash
+	}
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmterr(op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err = checkInbox(ctx, tx, msgs); err != nil {
+		return fmterr(op, err)
+	}
+	if err = checkUpdates(ctx, tx, msgs, uids, hashes); err != nil {
+		return fmterr(op, err)
+	}
+	addrIDs, err := saveCustomers(ctx, tx, msgs)
+	if err != nil {
+		return fmterr(op, err)
+	}
+	if err = savePayments(ctx, tx, msgs); err != nil {
+		return fmterr(op, err)
+	}
+	if err = saveOrders(ctx, tx, msgs, uids, hashes, addrIDs); err != nil {
+		return fmterr(op, err)
+	}
+	if err = appendRevisions(ctx, tx, msgs, uids); err != nil {
+		return fmterr(op, err)
+	}
+	orders := make([]*models.Order, len(msgs))
+	for i, m := range msgs {
+		orders[i] = m.Order
+	}
+	if err = notifySaved(tx, orders...); err != nil {
+		return fmterr(op, err)
+	}
+
+	args := make([]any, 0, 6*len(msgs))
+	for i, m := range msgs {
+		args = append(args, m.Source.Topic, m.Source.Partition, m.Source.Offset, uids[i], hashes[i], inboxApplied)
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO inbox (topic, partition, "offset", order_uid, payload_hash, status)
+		VALUES `+placeholders(len(msgs), 6), args...)
+	if err != nil {
+		return fmterr(op, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmterr(op, err)
+	}
+	return nil
+}
+
+// placeholders returns ($1, $2), ($3, $4)... for rows of n columns
+func placeholders(rows, n int) string {
+	var b strings.Builder
+	for r := range rows {
+		if r > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteByte('(')
+		for col := range n {
+			if col > 0 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(&b, "$%d", r*n+col+1)
+		}
+		b.WriteByte(')')
+	}
+	return b.String()
+}
+
+// copyRows streams rows into a table with COPY
+func copyRows(ctx c.Context, tx *sql.Tx, table string, cols []string, rows [][]any) error {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(table, cols...))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = stmt.Close() }()
+	for _, row := range rows {
+		if _, err = stmt.ExecContext(ctx, row...); err != nil {
+			return err
+		}
+	}
+	// flushes the buffered rows
+	_, err = stmt.ExecContext(ctx)
+	return err
+}
+
+// checkInbox rejects a batch holding messages that have been seen before
+func checkInbox(ctx c.Context, tx *sql.Tx, msgs []storage.Message) error {
+	args := make([]any, 0, 3*len(msgs))
+	for _, m := range msgs {
+		args = append(args, m.Source.Topic, m.Source.Partition, m.Source.Offset)
+	}
+	var seen int
+	err := tx.QueryRowContext(ctx, `SELECT count(*) FROM inbox WHERE (topic, partition, "offset") IN (`+
+		placeholders(len(msgs), 3)+`)`, args...).Scan(&seen)
+	if err != nil {
+		return err
+	}
+	if seen > 0 {
+		return fmt.Errorf("%w: %d messages seen before", storage.ErrBatchRejected, seen)
+	}
+	return nil
+}
+
+// checkUpdates does what checkUpdate does for every order at once, locking the stored rows
+func checkUpdates(ctx c.Context, tx *sql.Tx, msgs []storage.Message, uids, hashes []string) error {
+	// always locked in the same order, so concurrent batches wait instead of deadlocking
+	rows, err := tx.QueryContext(ctx, `SELECT order_uid, version, payload_hash, event_time FROM orders
+		WHERE order_uid = ANY($1) ORDER BY order_uid FOR UPDATE`, pq.Array(uids))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+	saved := make(map[string]stored)
+	for rows.Next() {
+		var (
+			uid string
+			st  stored
+		)
+		if err = rows.Scan(&uid, &st.version, &st.hash, &st.eventTime); err != nil {
+			return err
+		}
+		saved[uid] = st
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if len(saved) == 0 {
+		return nil
+	}
+
+	items, err := tx.QueryContext(ctx, `SELECT order_uid, rid, status FROM order_items WHERE order_uid = ANY($1)`, pq.Array(uids))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = items.Close() }()
+	statuses := make(map[string]map[string]int)
+	for items.Next() {
+		var (
+			uid, rid string
+			status   int
+		)
+		if err = items.Scan(&uid, &rid, &status); err != nil {
+			return err
+		}
+		if statuses[uid] == nil {
+			statuses[uid] = make(map[string]int)
+		}
+		statuses[uid][rid] = status
+	}
+	if err = items.Err(); err != nil {
+		return err
+	}
+
+	for i, m := range msgs {
+		st, ok := saved[uids[i]]
+		if !ok {
+			continue
+		}
+		if err = st.check(m.Order, hashes[i], eventTime(&m.Source)); err == nil {
+			err = checkTransitions(m.Order, statuses[uids[i]])
+		}
+		if err != nil {
+			return fmt.Errorf("%w: order %s: %w", storage.ErrBatchRejected, uids[i], err)
+		}
+	}
+	return nil
+}
+
+// addressKey is what makes an address unique
+type addressKey struct {
+	customerID, zip, city, address, region string
+}
+
+// saveCustomers creates or updates users and their addresses, returning the address ids.
+// A customer met more than once gets the details from their latest order, like it would one by one.
+func saveCustomers(ctx c.Context, tx *sql.Tx, msgs []storage.Message) (map[addressKey]int, error) {
+	users := make(map[string]int) // customer id to its last message
+	var customers []string
+	for i, m := range msgs {
+		if _, ok := users[m.Order.CustomerID]; !ok {
+			customers = append(customers, m.Order.CustomerID)
+		}
+		users[m.Order.CustomerID] = i
+	}
+	args := make([]any, 0, 4*len(customers))
+	for _, id := range customers {
+		d := msgs[users[id]].Order.Delivery
+		args = append(args, id, d.Name, d.Phone, d.Email)
+	}
+	rows, err := tx.QueryContext(ctx, `INSERT INTO users (customer_id, name, phone, email) VALUES `+placeholders(len(customers), 4)+`
+		ON CONFLICT (customer_id) DO UPDATE SET name = EXCLUDED.name, phone = EXCLUDED.phone, email = EXCLUDED.email
+		RETURNING id, customer_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	userIDs := make(map[string]int, len(customers))
+	for rows.Next() {
+		var (
+			id         int
+			customerID string
+		)
+		if err = rows.Scan(&id, &customerID); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		userIDs[customerID] = id
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var keys []addressKey
+	addrIDs := make(map[addressKey]int)
+	for _, m := range msgs {
+		d := m.Order.Delivery
+		key := addressKey{m.Order.CustomerID, d.Zip, d.City, d.Address, d.Region}
+		if _, ok := addrIDs[key]; !ok {
+			addrIDs[key] = 0
+			keys = append(keys, key)
+		}
+	}
+	args = make([]any, 0, 5*len(keys))
+	for _, k := range keys {
+		args = append(args, k.customerID, k.zip, k.city, k.address, k.region)
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO addresses (customer_id, zip, city, address, region) VALUES `+
+		placeholders(len(keys), 5)+` ON CONFLICT DO NOTHING`, args...)
+	if err != nil {
+		return nil, err
+	}
+	rows, err = tx.QueryContext(ctx, `SELECT id, customer_id, zip, city, address, region FROM addresses
+		WHERE (customer_id, zip, city, address, region) IN (`+placeholders(len(keys), 5)+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var (
+			id int
+			k  addressKey
+		)
+		if err = rows.Scan(&id, &k.customerID, &k.zip, &k.city, &k.address, &k.region); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		addrIDs[k] = id
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// check correlation between users and addresses
+	args = make([]any, 0, 2*len(keys))
+	for _, k := range keys {
+		args = append(args, userIDs[k.customerID], addrIDs[k])
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO users_addresses (user_id, address_id) VALUES `+
+		placeholders(len(keys), 2)+` ON CONFLICT DO NOTHING`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return addrIDs, nil
+}
+
+// savePayments creates or updates payments, the latest order wins for a transaction met more than once
+func savePayments(ctx c.Context, tx *sql.Tx, msgs []storage.Message) error {
+	last := make(map[string]int)
+	var txs []string
+	for i, m := range msgs {
+		if _, ok := last[m.Order.Payment.Transaction]; !ok {
+			txs = append(txs, m.Order.Payment.Transaction)
+		}
+		last[m.Order.Payment.Transaction] = i
+	}
+	args := make([]any, 0, 10*len(txs))
+	for _, t := range txs {
+		p := msgs[last[t]].Order.Payment
+		args = append(args, p.Transaction, p.RequestID, p.Currency, p.Provider, p.Amount, p.PaymentDT, p.Bank,
+			p.DeliveryCost, p.GoodsTotal, p.CustomFee)
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO payments
+    (transaction, request_id, currency, provider, amount, payment_dt, bank, delivery_cost, goods_total, custom_fee)
+    VALUES `+placeholders(len(txs), 10)+`
+    ON CONFLICT (transaction) DO UPDATE SET request_id = EXCLUDED.request_id, currency = EXCLUDED.currency,
+        provider = EXCLUDED.provider, amount = EXCLUDED.amount, payment_dt = EXCLUDED.payment_dt, bank = EXCLUDED.bank,
+        delivery_cost = EXCLUDED.delivery_cost, goods_total = EXCLUDED.goods_total, custom_fee = EXCLUDED.custom_fee`, args...)
+	return err
+}
+
+// saveOrders creates or updates orders and replaces their items
+func saveOrders(ctx c.Context, tx *sql.Tx, msgs []storage.Message, uids, hashes []string, addrIDs map[addressKey]int) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM order_items WHERE order_uid = ANY($1)`, pq.Array(uids))
+	if err != nil {
+		return err
+	}
+
+	args := make([]any, 0, 16*len(msgs))
+	for i, m := range msgs {
+		o, d := m.Order, m.Order.Delivery
+		addrID := addrIDs[addressKey{o.CustomerID, d.Zip, d.City, d.Address, d.Region}]
+		args = append(args, o.OrderUID, o.TrackNumber, o.Entry, addrID, o.Payment.Transaction, o.Locale, o.InternalSignature,
+			o.CustomerID, o.DeliveryService, o.ShardKey, o.SmID, o.DateCreated, o.OofShard, o.Version, hashes[i], eventTime(&m.Source))
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO orders
+    (order_uid, track_number, entry, delivery, payment, locale, internal_signature, customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard, version, payload_hash, event_time)
+    VALUES `+placeholders(len(msgs), 16)+`
+    ON CONFLICT (order_uid) DO UPDATE SET track_number = EXCLUDED.track_number, entry = EXCLUDED.entry, delivery = EXCLUDED.delivery,
+        payment = EXCLUDED.payment, locale = EXCLUDED.locale, internal_signature = EXCLUDED.internal_signature,
+        customer_id = EXCLUDED.customer_id, delivery_service = EXCLUDED.delivery_service, shardkey = EXCLUDED.shardkey,
+        sm_id = EXCLUDED.sm_id, date_created = EXCLUDED.date_created, oof_shard = EXCLUDED.oof_shard,
+        version = EXCLUDED.version, payload_hash = EXCLUDED.payload_hash, event_time = EXCLUDED.event_time`, args...)
+	if err != nil {
+		return err
+	}
+
+	var lines [][]any
+	for _, m := range msgs {
+		for i, item := range m.Order.Items {
+			lines = append(lines, []any{m.Order.OrderUID, i, item.NmID, item.ChrtID, item.TrackNumber, item.Price, item.RID,
+				item.Name, item.Sale, item.Size, item.TotalPrice, item.Brand, item.Status})
+		}
+	}
+	return copyRows(ctx, tx, "order_items", []string{"order_uid", "line", "nm_id", "chrt_id", "track_number", "price",
+		"rid", "name", "sale", "size", "total_price", "brand", "status"}, lines)
+}
+
+// appendRevisions does what appendRevision does for every order at once
+func appendRevisions(ctx c.Context, tx *sql.Tx, msgs []storage.Message, uids []string) error {
+	type last struct {
+		revision int
+		snapshot []byte
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT DISTINCT ON (order_uid) order_uid, revision, snapshot FROM order_revisions
+		WHERE order_uid = ANY($1) ORDER BY order_uid, revision DESC`, pq.Array(uids))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+	prev := make(map[string]last)
+	for rows.Next() {
+		var (
+			uid string
+			l   last
+		)
+		if err = rows.Scan(&uid, &l.revision, &l.snapshot); err != nil {
+			return err
+		}
+		prev[uid] = l
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	revisions := make([][]any, 0, len(msgs))
+	for _, m := range msgs {
+		snapshot, err := json.Marshal(m.Order)
+		if err != nil {
+			return err
+		}
+		p := prev[m.Order.OrderUID]
+		diff, err := revisionDiff(p.snapshot, snapshot)
+		if err != nil {
+			return err
+		}
+		topic, partition, offset := sourceColumns(&m.Source)
+		revisions = append(revisions, []any{m.Order.OrderUID, p.revision + 1, m.Order.Version, string(snapshot), diff,
+			topic, partition, offset})
+	}
+	return copyRows(ctx, tx, "order_revisions", []string{"order_uid", "revision", "version", "snapshot", "diff",
+		"source_topic", "source_partition", "source_offset"}, revisions)
+}
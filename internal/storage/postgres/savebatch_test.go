@@ -0,0 +1,100 @@
+package postgres_test
+
+import (
+	"context"
+	"l0/internal/models"
+	"l0/internal/storage"
+	"l0/internal/storage/postgres"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// batchOf wraps orders into messages with offsets of their own
+func batchOf(orders ...*models.Order) []storage.Message {
+	base := time.Now().UnixNano()
+	msgs := make([]storage.Message, len(orders))
+	for i, o := range orders {
+		msgs[i] = storage.Message{Order: o, Source: storage.Source{Topic: "orders", Partition: 0, Offset: base + int64(i)}}
+	}
+	return msgs
+}
+
+func TestSaveMessages(t *testing.T) {
+	st := newStorage(t)
+	ctx := context.Background()
+
+	// two orders of one customer at one address, one with an extra line
+	a, b, c := newTestOrder(), newTestOrder(), newTestOrder()
+	b.CustomerID, b.Delivery = a.CustomerID, a.Delivery
+	c.Items = append(c.Items, c.Items[0])
+	c.Items[1].RID += "x"
+	require.NoError(t, st.SaveMessages(ctx, batchOf(a, b, c)))
+	requireRoundTrip(t, st, a, b, c)
+
+	history, err := st.OrderHistory(ctx, c.OrderUID)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Nil(t, history[0].Diff)
+
+	// a newer version next to a new order diffs against the stored revision
+	c2 := c.Clone()
+	c2.Version = 1
+	c2.Items[0].Status = models.StatusAssembled
+	d := newTestOrder()
+	require.NoError(t, st.SaveMessages(ctx, batchOf(c2, d)))
+	requireRoundTrip(t, st, c2, d)
+
+	history, err = st.OrderHistory(ctx, c.OrderUID)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, []storage.Change{{Path: "items[0].status", From: float64(models.StatusAccepted), To: float64(models.StatusAssembled)},
+		{Path: "version", From: nil, To: float64(1)}}, history[1].Diff)
+}
+
+func TestSaveMessages_Rejected(t *testing.T) {
+	st := newStorage(t)
+	ctx := context.Background()
+
+	saved := newTestOrder()
+	msgs := batchOf(saved)
+	require.NoError(t, st.SaveMessages(ctx, msgs))
+
+	stale := saved.Clone()
+	stale.Version = -1
+	repeated := newTestOrder()
+	tests := []struct {
+		name string
+		msgs []storage.Message
+	}{
+		{"replayed", append(batchOf(newTestOrder()), msgs...)},
+		{"duplicate", batchOf(newTestOrder(), saved.Clone())},
+		{"stale", batchOf(newTestOrder(), stale)},
+		{"repeated", batchOf(repeated, repeated)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := st.SaveMessages(ctx, tt.msgs)
+			require.ErrorIs(t, err, storage.ErrBatchRejected)
+			// nothing from the batch is written
+			_, err = st.GetOrder(ctx, tt.msgs[0].Order.OrderUID)
+			assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+		})
+	}
+
+	// one by one they're told apart
+	for _, m := range batchOf(stale) {
+		assert.ErrorIs(t, st.SaveMessage(ctx, m.Order, m.Source), storage.ErrStaleOrder)
+	}
+}
+
+func TestSaveMessages_TooMany(t *testing.T) {
+	st := newStorage(t)
+	orders := make([]*models.Order, postgres.MaxBatchSize+1)
+	for i := range orders {
+		orders[i] = newTestOrder()
+	}
+	assert.ErrorIs(t, st.SaveMessages(context.Background(), batchOf(orders...)), storage.ErrBatchRejected)
+}
@@ -0,0 +1,134 @@
+package redis
+
+import (
+	c "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"l0/internal/config"
+	"l0/internal/metrics"
+	"l0/internal/models"
+	"l0/internal/storage"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+func fmterr(op string, err error) error {
+	return fmt.Errorf("%s: %w", op, err)
+}
+
+// Cache keeps orders as JSON in a Redis-compatible server shared by every replica,
+// so a rescheduled pod doesn't come up cold. Orders expire after a TTL of their own.
+type Cache struct {
+	rdb    *goredis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+// New creates a cache on the server. Connections are made on first use, so a server that's down
+// only fails the commands, which cache.Tiered counts as misses, instead of the service start.
+func New(cfg config.Redis) *Cache {
+	rdb := goredis.NewClient(&goredis.Options{
+		Addr:            cfg.Address,
+		Password:        cfg.Password,
+		DB:              cfg.DB,
+		DialTimeout:     cfg.Timeout,
+		ReadTimeout:     cfg.Timeout,
+		WriteTimeout:    cfg.Timeout,
+		MaxRetries:      1,
+		Protocol:        2,
+		DisableIdentity: true,
+	})
+	return &Cache{rdb: rdb, prefix: cfg.Prefix, ttl: cfg.TTL}
+}
+
+func (rc *Cache) key(uid string) string {
+	return rc.prefix + uid
+}
+
+// GetOrder gets an order, storage.ErrOrderNotFound if the server doesn't have it
+func (rc *Cache) GetOrder(ctx c.Context, orderID string) (*models.Order, error) {
+	const op = "storage.redis.GetOrder"
+	defer metrics.ObserveQuery(op, time.Now())
+
+	data, err := rc.rdb.Get(ctx, rc.key(orderID)).Bytes()
+	if errors.Is(err, goredis.Nil) {
+		return nil, storage.ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, fmterr(op, err)
+	}
+	var order models.Order
+	if err = json.Unmarshal(data, &order); err != nil {
+		return nil, fmterr(op, err)
+	}
+	return &order, nil
+}
+
+// SaveOrder saves an order just written to the database, replacing the cached one
+func (rc *Cache) SaveOrder(ctx c.Context, order *models.Order) error {
+	const op = "storage.redis.SaveOrder"
+	defer metrics.ObserveQuery(op, time.Now())
+
+	data, err := json.Marshal(order)
+	if err != nil {
+		return fmterr(op, err)
+	}
+	if err = rc.rdb.Set(ctx, rc.key(order.OrderUID), data, rc.ttl).Err(); err != nil {
+		return fmterr(op, err)
+	}
+	return nil
+}
+
+// LoadOrders fills the cache with orders read from the database, in a single round trip.
+// Orders already cached are kept: one may be a newer version saved since the read,
+// and only SaveOrder replaces orders, so a replica can't put back what another one's save replaced.
+func (rc *Cache) LoadOrders(ctx c.Context, orders []*models.Order) error {
+	const op = "storage.redis.LoadOrders"
+	defer metrics.ObserveQuery(op, time.Now())
+
+	pipe := rc.rdb.Pipeline()
+	for _, order := range orders {
+		data, err := json.Marshal(order)
+		if err != nil {
+			return fmterr(op, err)
+		}
+		pipe.SetNX(ctx, rc.key(order.OrderUID), data, rc.ttl)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmterr(op, err)
+	}
+	return nil
+}
+
+// Invalidate deletes an order older than the one saved. Lost invalidations only concern
+// the replica that lost them, so invalidating everything leaves the shared server alone.
+func (rc *Cache) Invalidate(ctx c.Context, inv storage.Invalidation) error {
+	const op = "storage.redis.Invalidate"
+	if inv.All {
+		return nil
+	}
+	order, err := rc.GetOrder(ctx, inv.OrderUID)
+	if errors.Is(err, storage.ErrOrderNotFound) {
+		return nil
+	}
+	if err == nil && !inv.Supersedes(order.Version) {
+		return nil
+	}
+	// undecodable orders go too; a newer one written meanwhile only costs a miss
+	if err = rc.rdb.Del(ctx, rc.key(inv.OrderUID)).Err(); err != nil {
+		return fmterr(op, err)
+	}
+	return nil
+}
+
+// Ping checks that the server is reachable
+func (rc *Cache) Ping(ctx c.Context) error {
+	return rc.rdb.Ping(ctx).Err()
+}
+
+// Close closes the connections
+func (rc *Cache) Close() error {
+	return rc.rdb.Close()
+}
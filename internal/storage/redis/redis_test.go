@@ -0,0 +1,223 @@
+package redis_test
+
+import (
+	"context"
+	"l0/internal/config"
+	"l0/internal/handlers"
+	"l0/internal/models"
+	"l0/internal/storage"
+	"l0/internal/storage/cache"
+	"l0/internal/storage/redis"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	_ handlers.Cacher      = (*redis.Cache)(nil)
+	_ handlers.Invalidator = (*redis.Cache)(nil)
+	_ cache.Remote         = (*redis.Cache)(nil)
+)
+
+func newTestOrder(id string) *models.Order {
+	return &models.Order{
+		OrderUID:    id,
+		TrackNumber: "WBILMTESTTRACK",
+		Payment:     models.Payment{Transaction: id, Currency: "USD"},
+		Items:       []models.Item{{ChrtID: 9934930, Name: "Mascaras", NmID: 2389212}},
+		DateCreated: "2021-11-26T06:22:19Z",
+	}
+}
+
+func newCache(t *testing.T, srv *respServer, ttl time.Duration) *redis.Cache {
+	t.Helper()
+	rc := redis.New(config.Redis{Address: srv.addr(), Prefix: "l0:order:", TTL: ttl, Timeout: time.Second})
+	t.Cleanup(func() { _ = rc.Close() })
+	return rc
+}
+
+func TestCache_SaveAndGetOrder(t *testing.T) {
+	srv := newRESPServer(t)
+	rc := newCache(t, srv, time.Minute)
+	ctx := context.Background()
+
+	order := newTestOrder("a")
+	require.NoError(t, rc.SaveOrder(ctx, order))
+	assert.Equal(t, []string{"l0:order:a"}, srv.keys())
+
+	got, err := rc.GetOrder(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, order, got)
+
+	_, err = rc.GetOrder(ctx, "b")
+	assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+}
+
+func TestCache_LoadOrders(t *testing.T) {
+	srv := newRESPServer(t)
+	rc := newCache(t, srv, time.Minute)
+	ctx := context.Background()
+
+	orders := []*models.Order{newTestOrder("a"), newTestOrder("b"), newTestOrder("c")}
+	require.NoError(t, rc.LoadOrders(ctx, orders))
+	for _, o := range orders {
+		got, err := rc.GetOrder(ctx, o.OrderUID)
+		require.NoError(t, err)
+		assert.Equal(t, o, got)
+	}
+}
+
+func TestCache_LoadOrdersKeepsSaved(t *testing.T) {
+	srv := newRESPServer(t)
+	rc := newCache(t, srv, time.Minute)
+	ctx := context.Background()
+
+	// a replica read v1 from the database, another one saved v2 meanwhile
+	saved := newTestOrder("a")
+	saved.Version = 2
+	require.NoError(t, rc.SaveOrder(ctx, saved))
+	read := newTestOrder("a")
+	read.Version = 1
+	require.NoError(t, rc.LoadOrders(ctx, []*models.Order{read, newTestOrder("b")}))
+
+	got, err := rc.GetOrder(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, saved, got)
+	_, err = rc.GetOrder(ctx, "b")
+	assert.NoError(t, err)
+
+	// a save still replaces what's cached
+	require.NoError(t, rc.SaveOrder(ctx, read))
+	got, err = rc.GetOrder(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, read, got)
+}
+
+func TestCache_TTL(t *testing.T) {
+	srv := newRESPServer(t)
+	rc := newCache(t, srv, 50*time.Millisecond)
+	ctx := context.Background()
+
+	require.NoError(t, rc.SaveOrder(ctx, newTestOrder("a")))
+	time.Sleep(60 * time.Millisecond)
+	_, err := rc.GetOrder(ctx, "a")
+	assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+}
+
+func TestCache_Invalidate(t *testing.T) {
+	srv := newRESPServer(t)
+	rc := newCache(t, srv, time.Minute)
+	ctx := context.Background()
+
+	order := newTestOrder("a")
+	order.Version = 2
+	require.NoError(t, rc.SaveOrder(ctx, order))
+
+	// as new as the cached one, or everything: kept
+	require.NoError(t, rc.Invalidate(ctx, storage.Invalidation{OrderUID: "a", Version: 2}))
+	require.NoError(t, rc.Invalidate(ctx, storage.Invalidation{All: true}))
+	_, err := rc.GetOrder(ctx, "a")
+	require.NoError(t, err)
+
+	require.NoError(t, rc.Invalidate(ctx, storage.Invalidation{OrderUID: "a", Version: 3}))
+	_, err = rc.GetOrder(ctx, "a")
+	assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+
+	require.NoError(t, rc.Invalidate(ctx, storage.Invalidation{OrderUID: "unknown", Version: 1}))
+}
+
+func TestTiered(t *testing.T) {
+	srv := newRESPServer(t)
+	ctx := context.Background()
+
+	// another replica filled the second level
+	other := cache.NewTiered(cache.NewCache(time.Minute, 10), newCache(t, srv, time.Minute))
+	defer other.Stop()
+	require.NoError(t, other.SaveOrder(ctx, newTestOrder("a")))
+
+	l1 := cache.NewCache(time.Minute, 10)
+	tiered := cache.NewTiered(l1, newCache(t, srv, time.Minute))
+	defer tiered.Stop()
+
+	got, err := tiered.GetOrder(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, newTestOrder("a"), got)
+	_, err = l1.GetOrder(ctx, "a") // filled on the way back
+	assert.NoError(t, err)
+
+	_, err = tiered.GetOrder(ctx, "b")
+	assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+
+	// a failing second level is a miss, writes still reach the first
+	srv.setDown(true)
+	_, err = tiered.GetOrder(ctx, "c")
+	assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+	require.NoError(t, tiered.SaveOrder(ctx, newTestOrder("c")))
+	_, err = tiered.GetOrder(ctx, "c")
+	assert.NoError(t, err)
+}
+
+// readingRemote reads through tiered in the middle of invalidating the second level
+type readingRemote struct {
+	*redis.Cache
+	tiered *cache.Tiered
+}
+
+func (r readingRemote) Invalidate(ctx context.Context, inv storage.Invalidation) error {
+	_, _ = r.tiered.GetOrder(ctx, inv.OrderUID)
+	return r.Cache.Invalidate(ctx, inv)
+}
+
+func TestTiered_InvalidateRace(t *testing.T) {
+	srv := newRESPServer(t)
+	ctx := context.Background()
+
+	l1 := cache.NewCache(time.Minute, 10)
+	remote := &readingRemote{Cache: newCache(t, srv, time.Minute)}
+	tiered := cache.NewTiered(l1, remote)
+	remote.tiered = tiered
+	defer tiered.Stop()
+
+	old := newTestOrder("a")
+	old.Version = 1
+	require.NoError(t, tiered.SaveOrder(ctx, old))
+
+	// another replica saved version 2: a read during the invalidation doesn't bring version 1 back
+	require.NoError(t, tiered.Invalidate(ctx, storage.Invalidation{OrderUID: "a", Version: 2}))
+	_, err := l1.GetOrder(ctx, "a")
+	assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+	_, err = tiered.GetOrder(ctx, "a")
+	assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+}
+
+func TestNew_Unreachable(t *testing.T) {
+	srv := newRESPServer(t)
+	addr := srv.addr()
+	_ = srv.l.Close()
+	ctx := context.Background()
+
+	// a server down at start doesn't keep the service from starting, the second level is only missed
+	c, err := cache.New(config.Cache{TTL: time.Minute, Limit: 10, L2: cache.L2Redis,
+		Redis: config.Redis{Address: addr, TTL: time.Minute, Timeout: 100 * time.Millisecond}})
+	require.NoError(t, err)
+	defer c.Stop()
+	require.NoError(t, c.SaveOrder(ctx, newTestOrder("a")))
+	_, err = c.GetOrder(ctx, "a")
+	assert.NoError(t, err)
+	_, err = c.GetOrder(ctx, "b")
+	assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+}
+
+func TestNew_SelectsRedis(t *testing.T) {
+	srv := newRESPServer(t)
+	c, err := cache.New(config.Cache{TTL: time.Minute, Limit: 10, L2: cache.L2Redis,
+		Redis: config.Redis{Address: srv.addr(), Prefix: "l0:order:", TTL: time.Minute, Timeout: time.Second}})
+	require.NoError(t, err)
+	defer c.Stop()
+	assert.IsType(t, &cache.Tiered{}, c)
+
+	_, err = cache.New(config.Cache{TTL: time.Minute, Limit: 10, L2: "memcached"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,216 @@
+package redis_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// respServer is an in-process stand-in for Redis speaking RESP2. It knows just the commands
+// the cache sends: GET, SET with EX or PX, DEL and PING; HELLO is refused like old servers do.
+type respServer struct {
+	l net.Listener
+
+	mu   sync.Mutex
+	data map[string]respValue
+	down bool // answer every command with an error
+}
+
+type respValue struct {
+	val     []byte
+	expires time.Time // zero for none
+}
+
+func newRESPServer(t *testing.T) *respServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &respServer{l: l, data: make(map[string]respValue)}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *respServer) addr() string {
+	return s.l.Addr().String()
+}
+
+func (s *respServer) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+func (s *respServer) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.data))
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (s *respServer) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		s.exec(w, args)
+		// replies to pipelined commands go out together
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("want an array, got %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = readLine(r); err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("want a bulk string, got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", errors.New("line not terminated by CRLF")
+	}
+	return line[:len(line)-2], nil
+}
+
+func (s *respServer) exec(w *bufio.Writer, args []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(args) == 0 {
+		fmt.Fprint(w, "-ERR empty command\r\n")
+		return
+	}
+	if s.down {
+		fmt.Fprint(w, "-ERR stand-in is down\r\n")
+		return
+	}
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		fmt.Fprint(w, "+PONG\r\n")
+	case "GET":
+		v, ok := s.get(args[1])
+		if !ok {
+			fmt.Fprint(w, "$-1\r\n")
+			return
+		}
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v.val), v.val)
+	case "SET", "SETNX":
+		v := respValue{val: []byte(args[2])}
+		nx := strings.ToUpper(args[0]) == "SETNX"
+		for i := 3; i < len(args); i++ {
+			opt := strings.ToUpper(args[i])
+			if opt == "NX" {
+				nx = true
+				continue
+			}
+			if i+1 == len(args) {
+				break
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil {
+				fmt.Fprint(w, "-ERR value is not an integer or out of range\r\n")
+				return
+			}
+			switch opt {
+			case "EX":
+				v.expires = time.Now().Add(time.Duration(n) * time.Second)
+			case "PX":
+				v.expires = time.Now().Add(time.Duration(n) * time.Millisecond)
+			}
+		}
+		_, exists := s.get(args[1])
+		if !exists || !nx {
+			s.data[args[1]] = v
+		}
+		switch {
+		case strings.ToUpper(args[0]) == "SETNX" && exists:
+			fmt.Fprint(w, ":0\r\n")
+		case strings.ToUpper(args[0]) == "SETNX":
+			fmt.Fprint(w, ":1\r\n")
+		case nx && exists:
+			fmt.Fprint(w, "$-1\r\n")
+		default:
+			fmt.Fprint(w, "+OK\r\n")
+		}
+	case "DEL":
+		n := 0
+		for _, k := range args[1:] {
+			if _, ok := s.get(k); ok {
+				delete(s.data, k)
+				n++
+			}
+		}
+		fmt.Fprintf(w, ":%d\r\n", n)
+	default:
+		fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", args[0])
+	}
+}
+
+func (s *respServer) get(key string) (respValue, bool) {
+	v, ok := s.data[key]
+	if ok && !v.expires.IsZero() && time.Now().After(v.expires) {
+		delete(s.data, key)
+		return v, false
+	}
+	return v, ok
+}
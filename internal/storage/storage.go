@@ -4,11 +4,26 @@ import (
 	c "context"
 	"errors"
 	"l0/internal/models"
+	"time"
 )
 
 var (
 	// ErrOrderNotFound explicitly states the order was not found
 	ErrOrderNotFound = errors.New("order not found")
+	// ErrCustomerNotFound explicitly states the customer was not found
+	ErrCustomerNotFound = errors.New("customer not found")
+	// ErrInvalidCursor is returned when a pagination cursor cannot be decoded
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrDuplicateMessage is returned when a message or its exact content has already been applied
+	ErrDuplicateMessage = errors.New("duplicate message")
+	// ErrConflictingOrder is returned when an order is re-delivered with the same version but different content
+	ErrConflictingOrder = errors.New("order re-delivered with different content")
+	// ErrStaleOrder is returned when a stored order has a newer version than the one being saved
+	ErrStaleOrder = errors.New("stale order version")
+	// ErrInvalidTransition is returned when an update moves an item to a status it can't reach
+	ErrInvalidTransition = errors.New("invalid item status transition")
+	// ErrBatchRejected is returned when a batch can't be saved as a whole and its messages should be saved one by one
+	ErrBatchRejected = errors.New("batch rejected")
 )
 
 // Storage can save and get orders
@@ -16,3 +31,91 @@ type Storage interface {
 	SaveOrder(c.Context, *models.Order) error
 	GetOrder(c.Context, string) (*models.Order, error)
 }
+
+// Source identifies the broker message an order came from
+type Source struct {
+	Topic     string    `json:"topic"`
+	Partition int       `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Time      time.Time `json:"-"` // when the message was produced, orders updates that carry no version
+}
+
+// Message is an order along with the broker message it came from
+type Message struct {
+	Order  *models.Order
+	Source Source
+}
+
+// Invalidation tells every replica an order was saved, so their caches drop older copies of it.
+// TrackNumber and Transaction let them stop answering lookups by keys the order may have joined.
+type Invalidation struct {
+	OrderUID    string `json:"order_uid"`
+	Version     int64  `json:"version"`
+	TrackNumber string `json:"track_number"`
+	Transaction string `json:"transaction"`
+	All         bool   `json:"all,omitempty"` // anything cached may be stale, e.g. invalidations were lost
+}
+
+// Supersedes tells whether the saved order replaces a cached copy of the given version: a lower one,
+// or any copy when the save carried no version and was ordered by its event time instead
+func (inv Invalidation) Supersedes(cached int64) bool {
+	return inv.Version == 0 || cached < inv.Version
+}
+
+// Change is a single field changed between two revisions of an order
+type Change struct {
+	Path string `json:"path"` // e.g. delivery.phone or items[0].status
+	From any    `json:"from"`
+	To   any    `json:"to"`
+}
+
+// Revision is an immutable snapshot of an order taken on every accepted write
+type Revision struct {
+	Revision  int          `json:"revision"`
+	Version   int64        `json:"version"`
+	Order     models.Order `json:"order"`
+	Diff      []Change     `json:"diff,omitempty"` // against the previous revision
+	Source    *Source      `json:"source,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// OrderFilter narrows down an order listing. Zero values mean "any".
+type OrderFilter struct {
+	CustomerID      string
+	DeliveryService string
+	Locale          string
+	CreatedFrom     time.Time // inclusive
+	CreatedTo       time.Time // exclusive
+	Status          *int      // at least one item with this status
+	Cursor          string    // opaque, taken from OrderPage.NextCursor
+	Limit           int
+}
+
+// OrderPage is a single page of an order listing
+type OrderPage struct {
+	Orders     []*models.Order `json:"orders"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// Customer is a customer's profile with a summary of their orders
+type Customer struct {
+	CustomerID   string     `json:"customer_id"`
+	Name         string     `json:"name"`
+	Phone        string     `json:"phone"`
+	Email        string     `json:"email"`
+	Orders       int        `json:"orders"`
+	Addresses    int        `json:"addresses"`
+	FirstOrderAt *time.Time `json:"first_order_at,omitempty"`
+	LastOrderAt  *time.Time `json:"last_order_at,omitempty"`
+}
+
+// Address is a delivery address a customer has used
+type Address struct {
+	ID         int        `json:"id"`
+	Zip        string     `json:"zip"`
+	City       string     `json:"city"`
+	Address    string     `json:"address"`
+	Region     string     `json:"region"`
+	Orders     int        `json:"orders"` // orders delivered there
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS order_items_status_idx;
+DROP INDEX IF EXISTS orders_delivery_service_idx;
+DROP INDEX IF EXISTS orders_customer_id_idx;
+DROP INDEX IF EXISTS orders_date_created_uid_idx;
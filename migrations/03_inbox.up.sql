@@ -0,0 +1,12 @@
+CREATE TABLE inbox (
+                       topic        VARCHAR(255) NOT NULL,
+                       partition    INTEGER NOT NULL,
+                       "offset"     BIGINT NOT NULL,
+                       order_uid    VARCHAR(255) NOT NULL,
+                       payload_hash CHAR(64) NOT NULL,
+                       status       VARCHAR(16) NOT NULL, -- applied | duplicate | conflict
+                       deliveries   INTEGER NOT NULL DEFAULT 1,
+                       received_at  TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY (topic, partition, "offset")
+);
+CREATE INDEX inbox_order_uid_idx ON inbox (order_uid, status);
@@ -0,0 +1,5 @@
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS payload_hash,
+    DROP COLUMN IF EXISTS version;
+
+COMMENT ON COLUMN inbox.status IS NULL;
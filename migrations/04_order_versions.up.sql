@@ -0,0 +1,5 @@
+ALTER TABLE orders
+    ADD COLUMN version      BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN payload_hash CHAR(64); -- NULL for orders saved before versioning
+
+COMMENT ON COLUMN inbox.status IS 'applied | duplicate | stale | conflict | rejected';
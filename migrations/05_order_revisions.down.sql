@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS order_revisions;
+DROP FUNCTION IF EXISTS order_revisions_append_only();
@@ -0,0 +1,22 @@
+CREATE TABLE order_revisions (
+                                 order_uid        VARCHAR(255) NOT NULL,
+                                 revision         INTEGER NOT NULL,
+                                 version          BIGINT NOT NULL,
+                                 snapshot         JSONB NOT NULL,
+                                 diff             JSONB, -- NULL for the first revision
+                                 source_topic     VARCHAR(255), -- source is NULL when not saved from Kafka
+                                 source_partition INTEGER,
+                                 source_offset    BIGINT,
+                                 created_at       TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY (order_uid, revision)
+);
+
+CREATE FUNCTION order_revisions_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'order_revisions is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER order_revisions_append_only
+    BEFORE UPDATE OR DELETE ON order_revisions
+    FOR EACH ROW EXECUTE FUNCTION order_revisions_append_only();
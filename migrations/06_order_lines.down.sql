@@ -0,0 +1,43 @@
+-- lossy: lines repeating an nm_id within an order are dropped, and every nm_id keeps
+-- the attributes of a single order. Fails if orders share a track number.
+DROP INDEX IF EXISTS orders_track_number_idx;
+ALTER TABLE orders ADD CONSTRAINT orders_track_number_key UNIQUE (track_number);
+
+CREATE TABLE items (
+                       nm_id INTEGER PRIMARY KEY,
+                       chrt_id INTEGER NOT NULL,
+                       price INTEGER,
+                       name VARCHAR(255) NOT NULL,
+                       size VARCHAR(50) NOT NULL,
+                       brand VARCHAR(255) NOT NULL
+);
+INSERT INTO items (nm_id, chrt_id, price, name, size, brand)
+SELECT DISTINCT ON (nm_id) nm_id, chrt_id, price, name, size, brand
+FROM order_items
+ORDER BY nm_id, order_uid, line;
+
+DELETE FROM order_items oi
+USING order_items dup
+WHERE dup.order_uid = oi.order_uid
+  AND dup.nm_id = oi.nm_id
+  AND dup.line < oi.line;
+
+UPDATE order_items oi
+SET track_number = o.track_number
+FROM orders o
+WHERE o.order_uid = oi.order_uid;
+
+ALTER TABLE order_items
+    DROP CONSTRAINT order_items_pkey,
+    DROP COLUMN line,
+    DROP COLUMN chrt_id,
+    DROP COLUMN price,
+    DROP COLUMN name,
+    DROP COLUMN size,
+    DROP COLUMN brand;
+ALTER TABLE order_items RENAME COLUMN nm_id TO item_id;
+ALTER TABLE order_items
+    ALTER COLUMN item_id TYPE INTEGER,
+    ADD PRIMARY KEY (order_uid, item_id),
+    ADD CONSTRAINT order_items_item_id_fkey FOREIGN KEY (item_id) REFERENCES items (nm_id) ON DELETE CASCADE,
+    ADD CONSTRAINT order_items_track_number_fkey FOREIGN KEY (track_number) REFERENCES orders (track_number) ON DELETE CASCADE;
@@ -0,0 +1,58 @@
+-- item attributes move from the shared items table onto each order line, so orders
+-- with the same nm_id no longer share the price, name or size of whichever came first
+ALTER TABLE order_items
+    DROP CONSTRAINT order_items_pkey,
+    DROP CONSTRAINT order_items_item_id_fkey,
+    DROP CONSTRAINT order_items_track_number_fkey;
+ALTER TABLE order_items RENAME COLUMN item_id TO nm_id;
+ALTER TABLE order_items
+    ALTER COLUMN nm_id TYPE BIGINT,
+    ADD COLUMN line    INTEGER, -- position in the order's items
+    ADD COLUMN chrt_id BIGINT,
+    ADD COLUMN price   INTEGER,
+    ADD COLUMN name    VARCHAR(255),
+    ADD COLUMN size    VARCHAR(50),
+    ADD COLUMN brand   VARCHAR(255);
+
+-- existing lines: take what the items table has, then prefer the latest revision snapshot,
+-- which holds the attributes and item track number exactly as they were received
+UPDATE order_items oi
+SET chrt_id = i.chrt_id, price = i.price, name = i.name, size = i.size, brand = i.brand
+FROM items i
+WHERE i.nm_id = oi.nm_id;
+
+UPDATE order_items oi
+SET chrt_id      = (e.item ->> 'chrt_id')::BIGINT,
+    price        = (e.item ->> 'price')::INTEGER,
+    name         = e.item ->> 'name',
+    size         = e.item ->> 'size',
+    brand        = e.item ->> 'brand',
+    track_number = e.item ->> 'track_number',
+    line         = e.pos - 1
+FROM (SELECT DISTINCT ON (order_uid) order_uid, snapshot FROM order_revisions ORDER BY order_uid, revision DESC) r,
+     jsonb_array_elements(r.snapshot -> 'items') WITH ORDINALITY AS e(item, pos)
+WHERE r.order_uid = oi.order_uid
+  AND e.item ->> 'rid' = oi.rid
+  AND (e.item ->> 'nm_id')::BIGINT = oi.nm_id;
+
+-- (order_uid, nm_id) is still unique here, renumber lines from 0 keeping the snapshot order
+UPDATE order_items oi
+SET line = n.line
+FROM (SELECT order_uid, nm_id, row_number() OVER (PARTITION BY order_uid ORDER BY line NULLS LAST, nm_id) - 1 AS line
+      FROM order_items) n
+WHERE n.order_uid = oi.order_uid
+  AND n.nm_id = oi.nm_id;
+
+ALTER TABLE order_items
+    ALTER COLUMN line SET NOT NULL,
+    ALTER COLUMN chrt_id SET NOT NULL,
+    ALTER COLUMN name SET NOT NULL,
+    ALTER COLUMN size SET NOT NULL,
+    ALTER COLUMN brand SET NOT NULL,
+    ADD PRIMARY KEY (order_uid, line);
+
+DROP TABLE items;
+
+-- a track number no longer identifies a single order
+ALTER TABLE orders DROP CONSTRAINT orders_track_number_key;
+CREATE INDEX IF NOT EXISTS orders_track_number_idx ON orders (track_number);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS orders_payment_idx;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS orders_payment_idx ON orders (payment);
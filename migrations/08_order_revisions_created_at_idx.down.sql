@@ -0,0 +1 @@
+DROP INDEX IF EXISTS order_revisions_created_at_idx;
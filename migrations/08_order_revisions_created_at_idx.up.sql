@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS order_revisions_created_at_idx ON order_revisions (created_at);
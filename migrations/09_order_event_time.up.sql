@@ -0,0 +1,2 @@
+ALTER TABLE orders
+    ADD COLUMN event_time TIMESTAMPTZ; -- when the message the order was saved from was produced, orders unversioned updates